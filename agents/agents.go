@@ -10,6 +10,7 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 // The Agent type is an object that can gather and return a result.
@@ -17,8 +18,22 @@ type Agent interface {
 	// return the config object for this agent
 	GetConfig() conf.SpoonConfigAgent
 
-	// fetch a result for this agent
-	Tick(sink.Sink) error
+	// fetch a result for this agent. The context is cancelled if Spoon is
+	// shutting down.
+	Tick(context.Context, sink.Sink) error
+}
+
+// timestampedAgent is implemented by agents whose metrics may carry their own
+// timestamps. These are given the sink itself rather than a batch, so that they
+// can create a batch for each timestamp.
+type timestampedAgent interface {
+	hasOwnTimestamps() bool
+}
+
+// stoppableAgent is implemented by agents that hold resources between ticks,
+// such as a child process, which must be released when the agent is stopped.
+type stoppableAgent interface {
+	stop()
 }
 
 // BuildAgent will return a pointer to a constructed object that follows
@@ -47,28 +62,80 @@ func BuildAgent(agentConfig *conf.SpoonConfigAgent) (Agent, error) {
 		return NewRandomAgent(agentConfig)
 	case "docker":
 		return NewDockerAgent(agentConfig)
+	case "load":
+		return NewLoadAgent(agentConfig)
+	case "process":
+		return NewProcessAgent(agentConfig)
+	case "cgroup":
+		return NewCgroupAgent(agentConfig)
+	case "pressure":
+		return NewPressureAgent(agentConfig)
+	case "stream":
+		return NewStreamAgent(agentConfig)
+	case "statsd":
+		return NewStatsdAgent(agentConfig)
+	case "logtail":
+		return NewLogtailAgent(agentConfig)
+	case "http":
+		return NewHTTPAgent(agentConfig)
+	case "port":
+		return NewPortAgent(agentConfig)
+	case "nginx":
+		return NewNginxAgent(agentConfig)
+	case "apache":
+		return NewApacheAgent(agentConfig)
+	case "redis":
+		return NewRedisAgent(agentConfig)
 	default:
 		return nil, fmt.Errorf("Unrecognised agent type '%v'", agentConfig.Type)
 	}
 }
 
+// TickAgent calls Tick on the given agent. If the sink supports batching, all
+// of the metrics from the tick are collected into a single batch which is
+// flushed once the tick is complete. Agents reporting their own timestamps
+// manage their own batches instead.
+func TickAgent(ctx context.Context, agent Agent, s sink.Sink) error {
+	bs, ok := s.(sink.BatchSink)
+	if !ok {
+		return agent.Tick(ctx, s)
+	}
+	if ta, ok := agent.(timestampedAgent); ok && ta.hasOwnTimestamps() {
+		return agent.Tick(ctx, s)
+	}
+	batch := bs.NewBatch(time.Now())
+	err := agent.Tick(ctx, batch)
+	if ferr := batch.Flush(); ferr != nil {
+		log.Printf("Failed to flush metrics for agent %v@%v: %s", agent.GetConfig().Type, agent.GetConfig().Path, ferr)
+	}
+	return err
+}
+
 // SpawnAgent will begin running the given agent in a loop based on the
-// interval for that agent.
-func SpawnAgent(agent Agent, s sink.Sink) error {
+// interval for that agent. The loop stops when the context is cancelled and
+// the returned channel is closed once any in-flight tick has returned and the
+// agent has released anything it holds between ticks.
+func SpawnAgent(ctx context.Context, agent Agent, s sink.Sink) (<-chan struct{}, error) {
+	done := make(chan struct{})
 
 	if !agent.GetConfig().Enabled {
 		log.Printf("Skipping agent %v because it is disabled.", agent.GetConfig())
-		return nil
+		close(done)
+		return done, nil
 	}
 
 	go func(agent Agent) {
+		defer close(done)
+		defer StopAgent(agent)
 		conf := agent.GetConfig()
 		log.Printf("Starting %s agent %s with interval %.2f seconds", conf.Type, conf.Path, conf.Interval)
 
 		// calculate random delay using half of the interval
 		delay := rand.Float64() * float64(conf.Interval) * 0.5
 		log.Printf("Delaying %s agent by %.2f seconds in order to spread the agents out and reduce spikes", conf.Type, delay)
-		time.Sleep(time.Duration(delay) * time.Second)
+		if !sleepContext(ctx, time.Duration(delay*float64(time.Second))) {
+			return
+		}
 
 		intervalNanos := float64(conf.Interval) * float64(time.Second)
 		spawnTime := time.Now().UnixNano()
@@ -77,7 +144,7 @@ func SpawnAgent(agent Agent, s sink.Sink) error {
 		for {
 			// do call to agent
 			tickStart = time.Now()
-			err := agent.Tick(s)
+			err := TickAgent(ctx, agent, s)
 			tickElapsed = time.Since(tickStart)
 			if err != nil {
 				log.Printf("Agent %v@%v returned an error after %v: %v", conf.Type, conf.Path, tickElapsed.String(), err.Error())
@@ -87,8 +154,32 @@ func SpawnAgent(agent Agent, s sink.Sink) error {
 
 			// now calculate time to sleep to meet the next tick time
 			sleep := intervalNanos * (1.0 - math.Mod(float64(time.Now().UnixNano()-spawnTime)/intervalNanos, float64(1)))
-			time.Sleep(time.Duration(sleep))
+			if !sleepContext(ctx, time.Duration(sleep)) {
+				log.Printf("Stopped %s agent %s", conf.Type, conf.Path)
+				return
+			}
 		}
 	}(agent)
-	return nil
+	return done, nil
+}
+
+// StopAgent releases anything the agent holds between ticks, such as a child
+// process. It must be called once the agent will not be ticked again.
+func StopAgent(agent Agent) {
+	if sa, ok := agent.(stoppableAgent); ok {
+		sa.stop()
+	}
+}
+
+// sleepContext sleeps for the given duration and returns true, or returns
+// false early if the context is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
 }
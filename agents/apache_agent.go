@@ -0,0 +1,133 @@
+package agents
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// apacheScoreboardStates are the names of the worker states shown by each
+// character of the scoreboard
+var apacheScoreboardStates = []struct {
+	char byte
+	name string
+}{
+	{'_', "waiting"},
+	{'S', "starting"},
+	{'R', "reading"},
+	{'W', "sending"},
+	{'K', "keepalive"},
+	{'D', "dns_lookup"},
+	{'C', "closing"},
+	{'L', "logging"},
+	{'G', "finishing"},
+	{'I', "idle_cleanup"},
+	{'.', "open"},
+}
+
+type apacheAgent struct {
+	statusPageSettings
+	config   conf.SpoonConfigAgent
+	counters *counterTracker
+}
+
+func NewApacheAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := statusPageSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.URL == "" {
+		s.URL = "http://127.0.0.1/server-status?auto"
+	}
+	return &apacheAgent{
+		statusPageSettings: s,
+		config:             *config,
+		counters:           newCounterTracker(),
+	}, nil
+}
+
+func (a *apacheAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *apacheAgent) Tick(ctx context.Context, s sink.Sink) error {
+	data, err := fetchStatusPage(ctx, a.config, a.statusPageSettings)
+	if err != nil {
+		return fmt.Errorf("failed to fetch apache status: %s", err)
+	}
+	status, err := parseApacheStatus(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse apache status: %s", err)
+	}
+
+	if v, ok := status.values["Total Accesses"]; ok {
+		a.counters.count(s, a.config.Path+".requests", a.config.Path+".requests_per_sec", uint64(v), nil)
+	}
+	if v, ok := status.values["Total kBytes"]; ok {
+		a.counters.count(s, a.config.Path+".sent_bytes", a.config.Path+".sent_bytes_per_sec", uint64(v*1024), nil)
+	}
+	for _, g := range []struct{ key, name string }{
+		{"BusyWorkers", "busy_workers"},
+		{"IdleWorkers", "idle_workers"},
+		{"ConnsTotal", "connections"},
+		{"ConnsAsyncWriting", "async_writing_connections"},
+		{"ConnsAsyncKeepAlive", "async_keepalive_connections"},
+		{"ConnsAsyncClosing", "async_closing_connections"},
+		{"Uptime", "uptime_seconds"},
+	} {
+		if v, ok := status.values[g.key]; ok {
+			s.Gauge(a.config.Path+"."+g.name, v)
+		}
+	}
+
+	if status.scoreboard != "" {
+		for _, state := range apacheScoreboardStates {
+			s.Gauge(a.config.Path+".scoreboard."+state.name, strings.Count(status.scoreboard, string(state.char)))
+		}
+	}
+	return nil
+}
+
+// apacheStatus is the content of the status page. The values are keyed by
+// their name on the page.
+type apacheStatus struct {
+	values     map[string]float64
+	scoreboard string
+}
+
+// parseApacheStatus parses the machine readable server-status?auto page,
+// which has a "Key: value" pair on each line.
+func parseApacheStatus(r io.Reader) (*apacheStatus, error) {
+	status := &apacheStatus{values: map[string]float64{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+		if key == "Scoreboard" {
+			status.scoreboard = value
+		} else if v, err := strconv.ParseFloat(value, 64); err == nil {
+			status.values[key] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(status.values) == 0 {
+		return nil, fmt.Errorf("page does not look like apache server-status?auto")
+	}
+	return status, nil
+}
@@ -0,0 +1,97 @@
+package agents
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseApacheStatus(t *testing.T) {
+	f, err := os.Open("testdata/status/apache_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	status, err := parseApacheStatus(f)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	for key, value := range map[string]float64{
+		"Total Accesses": 1000,
+		"Total kBytes":   2048,
+		"BusyWorkers":    3,
+		"IdleWorkers":    72,
+		"CPULoad":        0.0208333,
+	} {
+		if status.values[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, status.values[key])
+		}
+	}
+	if _, ok := status.values["ServerVersion"]; ok {
+		t.Error("expected the non numeric ServerVersion to be skipped")
+	}
+	if status.scoreboard != "__W_K_R___C....." {
+		t.Errorf("unexpected scoreboard %q", status.scoreboard)
+	}
+}
+
+func TestParseApacheStatusMalformed(t *testing.T) {
+	f, err := os.Open("testdata/status/malformed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := parseApacheStatus(f); err == nil {
+		t.Error("expected an error for a page that is not server-status?auto")
+	}
+}
+
+func TestApacheAgent(t *testing.T) {
+	server := statusPageServer(t, "testdata/status/apache_1", "testdata/status/apache_2")
+	defer server.Close()
+
+	agent, err := NewApacheAgent(testAgentConfig(t, "apache", "apache", map[string]interface{}{"url": server.URL + "/server-status?auto"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "apache.requests", 50)
+	s.expect(t, "apache.sent_bytes", 4096)
+	s.get(t, "apache.requests_per_sec")
+	s.expect(t, "apache.busy_workers", 3)
+	s.expect(t, "apache.idle_workers", 72)
+	s.expect(t, "apache.connections", 4)
+	s.expect(t, "apache.async_keepalive_connections", 2)
+	s.expect(t, "apache.uptime_seconds", 3600)
+	s.expect(t, "apache.scoreboard.waiting", 7)
+	s.expect(t, "apache.scoreboard.sending", 1)
+	s.expect(t, "apache.scoreboard.keepalive", 1)
+	s.expect(t, "apache.scoreboard.reading", 1)
+	s.expect(t, "apache.scoreboard.closing", 1)
+	s.expect(t, "apache.scoreboard.open", 5)
+	s.expect(t, "apache.scoreboard.starting", 0)
+}
+
+func TestApacheAgentMalformedPage(t *testing.T) {
+	server := statusPageServer(t, "testdata/status/malformed")
+	defer server.Close()
+
+	agent, err := NewApacheAgent(testAgentConfig(t, "apache", "apache", map[string]interface{}{"url": server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err == nil {
+		t.Error("expected an error for a malformed page")
+	}
+	s.expectMissing(t, "apache.busy_workers")
+}
@@ -0,0 +1,230 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type cgroupAgent struct {
+	cgroupAgentSettings
+	config      conf.SpoonConfigAgent
+	cgroupRegex *regexp.Regexp
+	counters    *counterTracker
+}
+
+type cgroupAgentSettings struct {
+	// Root is the cgroup v2 directory whose child cgroups are reported
+	Root        string `json:"root"`
+	CgroupRegex string `json:"cgroup_regex"`
+}
+
+func NewCgroupAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := cgroupAgentSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.Root == "" {
+		s.Root = "/sys/fs/cgroup/system.slice"
+	}
+	r, err := regexp.Compile(s.CgroupRegex)
+	if err != nil {
+		return nil, fmt.Errorf("cgroupAgent has invalid cgroup_regex: %s", err)
+	}
+	return &cgroupAgent{
+		cgroupAgentSettings: s,
+		config:              (*config),
+		cgroupRegex:         r,
+		counters:            newCounterTracker(),
+	}, nil
+}
+
+func (a *cgroupAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *cgroupAgent) Tick(ctx context.Context, s sink.Sink) error {
+	entries, err := ioutil.ReadDir(a.Root)
+	if err != nil {
+		return fmt.Errorf("failed to list cgroups in %s: %s", a.Root, err)
+	}
+	prefixes := []string{}
+	for _, e := range entries {
+		if !e.IsDir() || !a.cgroupRegex.MatchString(e.Name()) {
+			continue
+		}
+		name := sanitizePathPart(e.Name())
+		prefixes = append(prefixes, a.config.Path+"."+name+".")
+		a.doCgroup(s, filepath.Join(a.Root, e.Name()), sink.Tags{"cgroup": name})
+	}
+
+	// forget the counters of cgroups that have been removed
+	a.counters.retain(func(key string) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(key, p) {
+				return true
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// doCgroup reports the accounting files of a single cgroup. Files belonging to
+// controllers that are not enabled for the cgroup do not exist, so they are
+// skipped.
+func (a *cgroupAgent) doCgroup(s sink.Sink, directory string, tags sink.Tags) {
+	prefixPath := a.config.Path + ".{cgroup}"
+
+	if f, err := os.Open(filepath.Join(directory, "cpu.stat")); err == nil {
+		stats, perr := parseCgroupFlatKeyed(f)
+		f.Close()
+		if perr == nil {
+			a.doCPU(s, prefixPath, stats, tags)
+		}
+	}
+
+	// an unlimited cgroup has a memory.max of "max", in which case no limit
+	// is reported
+	if f, err := os.Open(filepath.Join(directory, "memory.max")); err == nil {
+		limit, limited, perr := parseCgroupLimit(f)
+		f.Close()
+		if perr == nil && limited {
+			s.Gauge(prefixPath+".memory_max_bytes", limit, tags)
+		}
+	}
+
+	for _, v := range []struct{ file, name string }{
+		{"memory.current", "memory_current_bytes"},
+		{"pids.current", "pids_current"},
+	} {
+		if f, err := os.Open(filepath.Join(directory, v.file)); err == nil {
+			value, perr := parseCgroupValue(f)
+			f.Close()
+			if perr == nil {
+				s.Gauge(prefixPath+"."+v.name, value, tags)
+			}
+		}
+	}
+
+	if f, err := os.Open(filepath.Join(directory, "io.stat")); err == nil {
+		devices, perr := parseCgroupNestedKeyed(f)
+		f.Close()
+		if perr == nil {
+			a.doIO(s, prefixPath, devices, tags)
+		}
+	}
+}
+
+// doCPU reports the cpu time used since the last tick, and the percentage of
+// a single cpu which that represents.
+func (a *cgroupAgent) doCPU(s sink.Sink, prefixPath string, stats map[string]uint64, tags sink.Tags) {
+	if usage, ok := stats["usage_usec"]; ok {
+		key := sink.ExpandPath(prefixPath+".cpu_usage_usec", tags)
+		if d, elapsed, ok := a.counters.delta(key, usage, time.Now()); ok {
+			s.Count(prefixPath+".cpu_usage_usec", d, tags)
+			if elapsed > 0 {
+				s.Gauge(prefixPath+".cpu_percent", float64(d)/1e6/elapsed*100, tags)
+			}
+		}
+	}
+}
+
+// doIO reports the bytes and operations read and written for each device
+func (a *cgroupAgent) doIO(s sink.Sink, prefixPath string, devices map[string]map[string]uint64, tags sink.Tags) {
+	ioPath := prefixPath + ".io.{device}"
+	for device, stats := range devices {
+		ioTags := sink.Tags{"cgroup": tags["cgroup"], "device": sanitizePathPart(device)}
+		a.counters.count(s, ioPath+".read_bytes", "", stats["rbytes"], ioTags)
+		a.counters.count(s, ioPath+".write_bytes", "", stats["wbytes"], ioTags)
+		a.counters.count(s, ioPath+".read_ops", "", stats["rios"], ioTags)
+		a.counters.count(s, ioPath+".write_ops", "", stats["wios"], ioTags)
+	}
+}
+
+// parseCgroupValue parses a file holding a single number, like memory.current
+func parseCgroupValue(r io.Reader) (uint64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// parseCgroupLimit parses a file holding a limit, like memory.max, which is
+// either a number or "max" when there is no limit. The boolean is false if
+// there is no limit.
+func parseCgroupLimit(r io.Reader) (uint64, bool, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, false, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, false, nil
+	}
+	limit, err := strconv.ParseUint(value, 10, 64)
+	return limit, err == nil, err
+}
+
+// parseCgroupFlatKeyed parses a file with a key and value on each line, like
+// cpu.stat.
+func parseCgroupFlatKeyed(r io.Reader) (map[string]uint64, error) {
+	output := map[string]uint64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed value for '%s': %s", fields[0], err)
+		}
+		output[fields[0]] = v
+	}
+	return output, scanner.Err()
+}
+
+// parseCgroupNestedKeyed parses a file with a key followed by key=value pairs
+// on each line, like io.stat:
+//
+//	8:0 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func parseCgroupNestedKeyed(r io.Reader) (map[string]map[string]uint64, error) {
+	output := map[string]map[string]uint64{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		values := map[string]uint64{}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("malformed field '%s'", field)
+			}
+			v, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed field '%s': %s", field, err)
+			}
+			values[parts[0]] = v
+		}
+		output[fields[0]] = values
+	}
+	return output, scanner.Err()
+}
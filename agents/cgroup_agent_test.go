@@ -0,0 +1,109 @@
+package agents
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseCgroupLimit(t *testing.T) {
+	for _, c := range []struct {
+		input   string
+		limit   uint64
+		limited bool
+	}{
+		{"104857600\n", 104857600, true},
+		{"max\n", 0, false},
+	} {
+		limit, limited, err := parseCgroupLimit(strings.NewReader(c.input))
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", c.input, err)
+		}
+		if limit != c.limit || limited != c.limited {
+			t.Errorf("expected %q to give %d %v, got %d %v", c.input, c.limit, c.limited, limit, limited)
+		}
+	}
+	if _, _, err := parseCgroupLimit(strings.NewReader("lots\n")); err == nil {
+		t.Error("expected an error for a malformed limit")
+	}
+}
+
+func TestCgroupAgent(t *testing.T) {
+	agent, err := NewCgroupAgent(testAgentConfig(t, "cgroup", "cg", map[string]interface{}{
+		"root":         "testdata/cgroup/system.slice",
+		"cgroup_regex": "\\.service$",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "cg.nginx_service.memory_current_bytes", 52428800)
+	s.expect(t, "cg.nginx_service.memory_max_bytes", 104857600)
+	s.expect(t, "cg.nginx_service.pids_current", 12)
+	s.expect(t, "cg.nginx_service.cpu_usage_usec", 0)
+	s.expect(t, "cg.nginx_service.io.8_0.read_bytes", 0)
+	s.expect(t, "cg.nginx_service.io.253_1.write_ops", 0)
+	if m := s.get(t, "cg.nginx_service.io.8_0.read_bytes"); m.tags["cgroup"] != "nginx_service" || m.tags["device"] != "8_0" {
+		t.Errorf("unexpected io tags %v", m.tags)
+	}
+
+	// the unlimited cgroup reports no limit at all
+	s.expect(t, "cg.redis_service.memory_current_bytes", 1024)
+	s.expectMissing(t, "cg.redis_service.memory_max_bytes")
+
+	s.expectMissing(t, "cg.ignored_mount.pids_current")
+}
+
+func TestCgroupAgentForgetsRemovedCgroups(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if out, err := exec.Command("cp", "-r", "testdata/cgroup/system.slice/.", root).CombinedOutput(); err != nil {
+		t.Fatalf("failed to copy fixtures: %s: %s", err, out)
+	}
+
+	agent, err := NewCgroupAgent(testAgentConfig(t, "cgroup", "cg", map[string]interface{}{
+		"root":         root,
+		"cgroup_regex": "\\.service$",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := agent.(*cgroupAgent).counters
+
+	if err := agent.Tick(context.Background(), newTestSink()); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	if _, ok := counters.prev["cg.redis_service.cpu_usage_usec"]; !ok {
+		t.Fatal("expected the redis cgroup to have a cpu counter")
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "redis.service")); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.Tick(context.Background(), newTestSink()); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	for key := range counters.prev {
+		if strings.HasPrefix(key, "cg.redis_service.") {
+			t.Errorf("expected counter %s to be forgotten", key)
+		}
+	}
+	if _, ok := counters.prev["cg.nginx_service.cpu_usage_usec"]; !ok {
+		t.Error("expected the nginx cgroup counters to be kept")
+	}
+}
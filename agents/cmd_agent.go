@@ -1,31 +1,35 @@
 package agents
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/AstromechZA/spoon/conf"
-	"github.com/AstromechZA/spoon/constants"
 	"github.com/AstromechZA/spoon/sink"
 	"golang.org/x/net/context"
 )
 
 type cmdAgent struct {
 	cmdAgentSettings
-	config     conf.SpoonConfigAgent
-	lineRegexp regexp.Regexp
+	config conf.SpoonConfigAgent
 }
 
 type cmdAgentSettings struct {
 	Command []string `json:"cmd"`
+	// Format is the format of the command output: lines (the default),
+	// json, prometheus, graphite, or nagios
+	Format string `json:"format"`
+	// PrometheusLabels controls whether prometheus labels are reported as
+	// "tags" (the default) or written into the path as "segments"
+	PrometheusLabels string `json:"prometheus_labels"`
 }
 
 func NewCMDAgent(config *conf.SpoonConfigAgent) (Agent, error) {
@@ -38,10 +42,26 @@ func NewCMDAgent(config *conf.SpoonConfigAgent) (Agent, error) {
 		return nil, errors.New("cmdAgent 'cmd' setting must have at least one item")
 	}
 
+	s.Format = strings.ToLower(s.Format)
+	switch s.Format {
+	case "":
+		s.Format = cmdFormatLines
+	case cmdFormatLines, cmdFormatJSON, cmdFormatPrometheus, cmdFormatGraphite, cmdFormatNagios:
+	default:
+		return nil, fmt.Errorf("cmdAgent 'format' setting '%s' must be one of lines, json, prometheus, graphite, or nagios", s.Format)
+	}
+
+	switch s.PrometheusLabels {
+	case "":
+		s.PrometheusLabels = "tags"
+	case "tags", "segments":
+	default:
+		return nil, fmt.Errorf("cmdAgent 'prometheus_labels' setting '%s' must be either tags or segments", s.PrometheusLabels)
+	}
+
 	return &cmdAgent{
 		cmdAgentSettings: s,
 		config:           *config,
-		lineRegexp:       *regexp.MustCompile("^(" + constants.ValidAgentPathRegex + ")*\\s+([\\-0-9\\.]+)\\s*$"),
 	}, nil
 }
 
@@ -49,42 +69,116 @@ func (a *cmdAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *cmdAgent) Tick(s sink.Sink) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.config.Interval)*time.Second)
+// hasOwnTimestamps is true for the formats that can carry a timestamp
+func (a *cmdAgent) hasOwnTimestamps() bool {
+	return a.Format == cmdFormatGraphite || a.Format == cmdFormatPrometheus
+}
+
+func (a *cmdAgent) Tick(ctx context.Context, s sink.Sink) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.config.Interval)*time.Second)
 	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
 	defer cancel()
 	start := time.Now()
 	exitcode := 0
+	started := true
 	out, err := cmd.Output()
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
-			log.Printf("%v command failed %s: %s", a.Command[0], err, ee.Stderr)
 			ws := ee.Sys().(syscall.WaitStatus)
 			exitcode = ws.ExitStatus()
+			// non-zero exit codes are expected from nagios plugins
+			if a.Format != cmdFormatNagios || ws.ExitStatus() < 0 {
+				log.Printf("%v command failed %s: %s", a.Command[0], err, ee.Stderr)
+			}
 		} else {
+			started = false
 			log.Printf("%v command failed %s", a.Command[0], err)
 		}
 	}
-	s.Gauge(a.config.Path+".exit_code", exitcode)
+
+	metrics, perr := a.parseOutput(bytes.NewReader(out))
+	if perr != nil {
+		log.Printf("%v command output could not be parsed as %s: %s", a.Command[0], a.Format, perr)
+	}
+
+	ts := newTimestampedSink(s, start)
+	ts.Gauge(a.config.Path+".exit_code", exitcode)
 	elapsed := time.Now().Sub(start)
-	s.Gauge(a.config.Path+".elapsed_seconds", elapsed.Seconds())
-
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if a.lineRegexp.MatchString(line) {
-			groups := a.lineRegexp.FindStringSubmatch(line)
-			subpath := groups[1]
-			value, err := strconv.ParseFloat(groups[2], 64)
-			if err != nil {
-				log.Printf("Path %v had value %v which was not a valid 64bit float", subpath, groups[2])
-			}
-			if subpath[0] == '.' {
-				subpath = a.config.Path + ".values" + subpath
-			}
-			s.Gauge(subpath, value)
+	ts.Timing(a.config.Path+".elapsed_seconds", elapsed.Seconds())
+	if a.Format == cmdFormatNagios {
+		// a command that could not be started is UNKNOWN
+		status := 3
+		if started {
+			status = nagiosStatus(exitcode)
 		}
+		ts.Gauge(a.config.Path+".status", status)
 	}
+	for _, m := range metrics {
+		path := m.path
+		if !m.absolute {
+			path = a.config.Path + ".values." + path
+		}
+		ts.at(m.timestamp).Gauge(path, m.value, m.tags)
+	}
+	return ts.Flush()
+}
 
-	return nil
+func (a *cmdAgent) parseOutput(r io.Reader) ([]cmdMetric, error) {
+	switch a.Format {
+	case cmdFormatJSON:
+		return parseCmdJSON(r)
+	case cmdFormatPrometheus:
+		return parsePrometheusText(r, a.PrometheusLabels == "tags")
+	case cmdFormatGraphite:
+		return parseCmdGraphite(r)
+	case cmdFormatNagios:
+		return parseNagiosPerfdata(r)
+	default:
+		return parseCmdLines(r)
+	}
+}
+
+// timestampedSink sends metrics to a batch for each distinct timestamp when the
+// sink supports batching. Otherwise timestamps can't be honoured, and the
+// metrics are sent to the sink directly.
+type timestampedSink struct {
+	sink.Sink
+	parent  sink.Sink
+	now     time.Time
+	batches map[int64]sink.Batch
+}
+
+func newTimestampedSink(s sink.Sink, now time.Time) *timestampedSink {
+	ts := &timestampedSink{Sink: s, parent: s, now: now, batches: map[int64]sink.Batch{}}
+	ts.Sink = ts.at(time.Time{})
+	return ts
+}
+
+// at returns the sink to use for metrics at the given time. The zero time
+// means the time of the tick.
+func (ts *timestampedSink) at(t time.Time) sink.Sink {
+	bs, ok := ts.parent.(sink.BatchSink)
+	if !ok {
+		return ts.parent
+	}
+	if t.IsZero() {
+		t = ts.now
+	}
+	b, ok := ts.batches[t.UnixNano()]
+	if !ok {
+		b = bs.NewBatch(t)
+		ts.batches[t.UnixNano()] = b
+	}
+	return b
+}
+
+// Flush flushes each of the batches and returns the last error
+func (ts *timestampedSink) Flush() error {
+	var lastErr error
+	for _, b := range ts.batches {
+		if err := b.Flush(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
 }
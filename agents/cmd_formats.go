@@ -0,0 +1,432 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AstromechZA/spoon/constants"
+	"github.com/AstromechZA/spoon/sink"
+)
+
+// cmdMetric is a single value parsed from the output of a command. The path
+// is relative to the values path of the agent unless it is absolute, and a
+// zero timestamp means the time of the tick.
+type cmdMetric struct {
+	path      string
+	absolute  bool
+	value     float64
+	tags      sink.Tags
+	timestamp time.Time
+}
+
+const (
+	cmdFormatLines      = "lines"
+	cmdFormatJSON       = "json"
+	cmdFormatPrometheus = "prometheus"
+	cmdFormatGraphite   = "graphite"
+	cmdFormatNagios     = "nagios"
+)
+
+var cmdLineRegexp = regexp.MustCompile("^(" + constants.ValidAgentPathRegex + ")*\\s+([\\-0-9\\.]+)\\s*$")
+
+var cmdGraphiteLineRegexp = regexp.MustCompile("^(" + constants.ValidAgentPathRegex + ")\\s+(\\S+)(?:\\s+([0-9\\.]+))?\\s*$")
+
+// parseCmdLines parses the default "path value" output. Paths starting with a
+// dot are relative to the agent.
+func parseCmdLines(r io.Reader) ([]cmdMetric, error) {
+	output := []cmdMetric{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		groups := cmdLineRegexp.FindStringSubmatch(line)
+		if groups == nil || groups[1] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(groups[2], 64)
+		if err != nil {
+			log.Printf("Path %v had value %v which was not a valid 64bit float", groups[1], groups[2])
+		}
+		output = append(output, cmdPathMetric(groups[1], value))
+	}
+	return output, scanner.Err()
+}
+
+// parseCmdGraphite parses Graphite plaintext "path value [timestamp]" lines.
+// Like the default format, paths starting with a dot are relative to the
+// agent.
+func parseCmdGraphite(r io.Reader) ([]cmdMetric, error) {
+	output := []cmdMetric{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		groups := cmdGraphiteLineRegexp.FindStringSubmatch(line)
+		if groups == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(groups[2], 64)
+		if err != nil {
+			log.Printf("Path %v had value %v which was not a valid 64bit float", groups[1], groups[2])
+			continue
+		}
+		m := cmdPathMetric(groups[1], value)
+		if groups[3] != "" {
+			seconds, err := strconv.ParseFloat(groups[3], 64)
+			if err != nil {
+				log.Printf("Path %v had timestamp %v which was not a valid number", groups[1], groups[3])
+				continue
+			}
+			whole, frac := math.Modf(seconds)
+			m.timestamp = time.Unix(int64(whole), int64(frac*1e9))
+		}
+		output = append(output, m)
+	}
+	return output, scanner.Err()
+}
+
+func cmdPathMetric(path string, value float64) cmdMetric {
+	if strings.HasPrefix(path, ".") {
+		return cmdMetric{path: path[1:], value: value}
+	}
+	return cmdMetric{path: path, absolute: true, value: value}
+}
+
+// parseCmdJSON parses a JSON object. Nested keys are joined with dots to form
+// the path, array items use their index, and booleans are reported as 0 or 1.
+// Strings are only reported if they hold a number.
+func parseCmdJSON(r io.Reader) ([]cmdMetric, error) {
+	var data interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse json: %s", err)
+	}
+	output := []cmdMetric{}
+	flattenCmdJSON("", data, &output)
+	return output, nil
+}
+
+func flattenCmdJSON(path string, data interface{}, output *[]cmdMetric) {
+	add := func(value float64) {
+		if path != "" {
+			*output = append(*output, cmdMetric{path: path, value: value})
+		}
+	}
+	join := func(key string) string {
+		key = sanitizePathPart(key)
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := data.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenCmdJSON(join(k), v[k], output)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenCmdJSON(join(strconv.Itoa(i)), item, output)
+		}
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			add(f)
+		}
+	case bool:
+		if v {
+			add(1)
+		} else {
+			add(0)
+		}
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			add(f)
+		}
+	}
+}
+
+// parsePrometheusText parses the Prometheus text exposition format. Each label
+// becomes a path segment after the metric name. If labelsAsTags is true, these
+// are tag segments, otherwise the label values are written into the path.
+// Lines that cannot be parsed are logged and skipped.
+func parsePrometheusText(r io.Reader, labelsAsTags bool) ([]cmdMetric, error) {
+	output := []cmdMetric{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m, err := parsePrometheusLine(line, labelsAsTags)
+		if err != nil {
+			log.Printf("Skipping prometheus line %d: %s", lineNumber, err)
+			continue
+		}
+		output = append(output, m)
+	}
+	return output, scanner.Err()
+}
+
+func parsePrometheusLine(line string, labelsAsTags bool) (cmdMetric, error) {
+	m := cmdMetric{}
+
+	// the name runs until the labels or the value
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return m, fmt.Errorf("missing value")
+	}
+	name := line[:end]
+	rest := line[end:]
+
+	labels := map[string]string{}
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		labels, rest, err = parsePrometheusLabels(rest[1:])
+		if err != nil {
+			return m, err
+		}
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return m, fmt.Errorf("expected a value and optional timestamp after '%s'", name)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return m, fmt.Errorf("invalid value '%s'", fields[0])
+	}
+	m.value = value
+	if len(fields) == 2 {
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return m, fmt.Errorf("invalid timestamp '%s'", fields[1])
+		}
+		m.timestamp = time.Unix(0, ms*int64(time.Millisecond))
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	m.path = sanitizePathPart(name)
+	if labelsAsTags && len(keys) > 0 {
+		m.tags = sink.Tags{}
+	}
+	for _, k := range keys {
+		key := sanitizePathPart(k)
+		if labelsAsTags {
+			m.path += ".{" + key + "}"
+			m.tags[key] = sanitizePathPart(labels[k])
+		} else {
+			m.path += "." + sanitizePathPart(labels[k])
+		}
+	}
+	return m, nil
+}
+
+// parsePrometheusLabels parses the content of a label set, starting after the
+// opening brace, and returns the labels and the remainder of the line.
+func parsePrometheusLabels(s string) (map[string]string, string, error) {
+	labels := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			return nil, "", fmt.Errorf("malformed labels")
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		if !strings.HasPrefix(s, "\"") {
+			return nil, "", fmt.Errorf("label '%s' value is not quoted", key)
+		}
+
+		// read the quoted value, handling escapes
+		value := []byte{}
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value = append(value, '\n')
+				default:
+					value = append(value, s[i])
+				}
+				continue
+			}
+			value = append(value, s[i])
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("label '%s' value is not terminated", key)
+		}
+		labels[key] = string(value)
+
+		s = strings.TrimLeft(s[i+1:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		}
+	}
+}
+
+// nagiosUnits are the multipliers which convert perfdata values into base
+// units, which are seconds and bytes. Percentages and counters are unchanged.
+var nagiosUnits = map[string]float64{
+	"":   1,
+	"%":  1,
+	"c":  1,
+	"s":  1,
+	"ms": 1e-3,
+	"us": 1e-6,
+	"B":  1,
+	"KB": 1024,
+	"MB": 1024 * 1024,
+	"GB": 1024 * 1024 * 1024,
+	"TB": 1024 * 1024 * 1024 * 1024,
+}
+
+var nagiosValueRegexp = regexp.MustCompile(`^([\-0-9\.eE\+]+)([a-zA-Z%]*)$`)
+
+// nagiosStatus maps the exit code of a Nagios plugin to its status: 0 for OK,
+// 1 for WARNING, 2 for CRITICAL, and 3 for UNKNOWN. Any other exit code is
+// treated as UNKNOWN.
+func nagiosStatus(exitcode int) int {
+	if exitcode < 0 || exitcode > 3 {
+		return 3
+	}
+	return exitcode
+}
+
+// parseNagiosPerfdata parses the performance data from the output of a Nagios
+// plugin. This follows the first "|" on the first line and, if the long text
+// on the following lines contains a "|", everything after it.
+//
+// Each item looks like 'label'=value[UOM];[warn];[crit];[min];[max] and is
+// reported as label.value along with label.warn, label.crit, label.min, and
+// label.max if they are present. Thresholds given as ranges use their upper
+// bound, or their lower bound if the range is open ended. Items that cannot be
+// parsed are logged and skipped.
+func parseNagiosPerfdata(r io.Reader) ([]cmdMetric, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitN(string(data), "\n", 2)
+	perfdata := ""
+	if i := strings.Index(lines[0], "|"); i >= 0 {
+		perfdata = lines[0][i+1:]
+	}
+	if len(lines) > 1 {
+		if i := strings.Index(lines[1], "|"); i >= 0 {
+			perfdata += " " + lines[1][i+1:]
+		}
+	}
+
+	output := []cmdMetric{}
+	for _, item := range splitNagiosPerfdata(perfdata) {
+		eq := strings.LastIndex(item, "=")
+		if eq <= 0 {
+			log.Printf("Skipping malformed perfdata '%s'", item)
+			continue
+		}
+		label := item[:eq]
+		if strings.HasPrefix(label, "'") && strings.HasSuffix(label, "'") && len(label) > 1 {
+			label = strings.Replace(label[1:len(label)-1], "''", "'", -1)
+		}
+		path := sanitizePathPart(label)
+
+		fields := strings.Split(item[eq+1:], ";")
+		groups := nagiosValueRegexp.FindStringSubmatch(fields[0])
+		if groups == nil {
+			// plugins report U when the value could not be determined
+			continue
+		}
+		multiplier, ok := nagiosUnits[groups[2]]
+		if !ok {
+			log.Printf("Skipping perfdata '%s' with unknown unit '%s'", label, groups[2])
+			continue
+		}
+		value, err := strconv.ParseFloat(groups[1], 64)
+		if err != nil {
+			log.Printf("Skipping perfdata '%s' with invalid value '%s'", label, groups[1])
+			continue
+		}
+		output = append(output, cmdMetric{path: path + ".value", value: value * multiplier})
+
+		for i, name := range []string{"warn", "crit", "min", "max"} {
+			if i+1 >= len(fields) {
+				break
+			}
+			if v, ok := parseNagiosThreshold(fields[i+1]); ok {
+				output = append(output, cmdMetric{path: path + "." + name, value: v * multiplier})
+			}
+		}
+	}
+	return output, nil
+}
+
+// splitNagiosPerfdata splits perfdata on whitespace, except within quoted
+// labels.
+func splitNagiosPerfdata(perfdata string) []string {
+	items := []string{}
+	current := []byte{}
+	quoted := false
+	for i := 0; i < len(perfdata); i++ {
+		c := perfdata[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+			current = append(current, c)
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if len(current) > 0 {
+				items = append(items, string(current))
+				current = []byte{}
+			}
+		default:
+			current = append(current, c)
+		}
+	}
+	if len(current) > 0 {
+		items = append(items, string(current))
+	}
+	return items
+}
+
+// parseNagiosThreshold parses a threshold, which may be a plain number or a
+// range like "10:20", "~:20", "10:", or "@10:20".
+func parseNagiosThreshold(threshold string) (float64, bool) {
+	threshold = strings.TrimPrefix(strings.TrimSpace(threshold), "@")
+	if threshold == "" {
+		return 0, false
+	}
+	parts := strings.Split(threshold, ":")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i] == "" || parts[i] == "~" {
+			continue
+		}
+		v, err := strconv.ParseFloat(parts[i], 64)
+		return v, err == nil
+	}
+	return 0, false
+}
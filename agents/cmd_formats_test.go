@@ -0,0 +1,228 @@
+package agents
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePrometheusTextSkipsBadLines(t *testing.T) {
+	input := strings.Join([]string{
+		"# TYPE requests counter",
+		`requests{code="200"} 10`,
+		`requests{code="500" 3`,
+		"requests_bad abc",
+		"up 1",
+	}, "\n")
+	metrics, err := parsePrometheusText(strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %+v", metrics)
+	}
+	if metrics[0].value != 10 || metrics[1].path != "up" || metrics[1].value != 1 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+}
+
+// expectCmdMetrics checks that the metrics have exactly the expected values,
+// keyed by path
+func expectCmdMetrics(t *testing.T, metrics []cmdMetric, expected map[string]float64) {
+	t.Helper()
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.path] = m.value
+	}
+	if len(values) != len(expected) || len(metrics) != len(expected) {
+		t.Fatalf("expected %v, got %+v", expected, metrics)
+	}
+	for path, v := range expected {
+		if got, ok := values[path]; !ok || got != v {
+			t.Errorf("expected %s to be %v, got %v (%v)", path, v, got, ok)
+		}
+	}
+}
+
+func TestParseCmdJSON(t *testing.T) {
+	input := `{
+		"load": {"one": 1.5, "five": "2.25", "name": "web"},
+		"disks": [{"used": 10}, {"used": 20}],
+		"up": true,
+		"down": false,
+		"odd key": 3,
+		"missing": null
+	}`
+	metrics, err := parseCmdJSON(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	expectCmdMetrics(t, metrics, map[string]float64{
+		"load.one":     1.5,
+		"load.five":    2.25,
+		"disks.0.used": 10,
+		"disks.1.used": 20,
+		"up":           1,
+		"down":         0,
+		"odd_key":      3,
+	})
+	for _, m := range metrics {
+		if m.absolute {
+			t.Errorf("expected %s to be relative", m.path)
+		}
+	}
+
+	if _, err := parseCmdJSON(strings.NewReader("{")); err == nil {
+		t.Error("expected an error for invalid json")
+	}
+}
+
+func TestParseCmdGraphite(t *testing.T) {
+	input := strings.Join([]string{
+		".requests 10 1500000000",
+		"host.load 0.5 1500000000.25",
+		".queue 3",
+		".bad abc 1500000000",
+		"not a metric line",
+	}, "\n")
+	metrics, err := parseCmdGraphite(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	expectCmdMetrics(t, metrics, map[string]float64{
+		"requests":  10,
+		"host.load": 0.5,
+		"queue":     3,
+	})
+	if !metrics[0].timestamp.Equal(time.Unix(1500000000, 0)) || metrics[0].absolute {
+		t.Errorf("unexpected metric %+v", metrics[0])
+	}
+	if !metrics[1].timestamp.Equal(time.Unix(1500000000, 250000000)) || !metrics[1].absolute {
+		t.Errorf("unexpected metric %+v", metrics[1])
+	}
+	if !metrics[2].timestamp.IsZero() {
+		t.Errorf("expected no timestamp, got %v", metrics[2].timestamp)
+	}
+}
+
+func TestParsePrometheusTextLabels(t *testing.T) {
+	input := strings.Join([]string{
+		"# HELP http_requests_total requests",
+		`http_requests_total{method="post",code="200"} 1027 1395066363000`,
+		`http_requests_total{code="400", method="get\"x"} 3`,
+		"up 1",
+	}, "\n")
+
+	metrics, err := parsePrometheusText(strings.NewReader(input), true)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %+v", metrics)
+	}
+	m := metrics[0]
+	if m.path != "http_requests_total.{code}.{method}" || m.value != 1027 {
+		t.Errorf("unexpected metric %+v", m)
+	}
+	if m.tags["code"] != "200" || m.tags["method"] != "post" {
+		t.Errorf("expected code and method tags, got %v", m.tags)
+	}
+	if !m.timestamp.Equal(time.Unix(1395066363, 0)) {
+		t.Errorf("expected the millisecond timestamp to be converted, got %v", m.timestamp)
+	}
+	if !metrics[1].timestamp.IsZero() || metrics[2].tags != nil {
+		t.Errorf("unexpected metrics %+v", metrics[1:])
+	}
+
+	// without tags, the label values become path segments
+	metrics, err = parsePrometheusText(strings.NewReader(input), false)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if metrics[0].path != "http_requests_total.200.post" || metrics[0].tags != nil {
+		t.Errorf("unexpected metric %+v", metrics[0])
+	}
+}
+
+func TestParseNagiosPerfdataSkipsBadItems(t *testing.T) {
+	input := "DISK OK | used=10MB;20;30 odd=5furlongs broken 'free space'=1KB\n"
+	metrics, err := parseNagiosPerfdata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.path] = m.value
+	}
+	expected := map[string]float64{
+		"used.value":       10 * 1024 * 1024,
+		"used.warn":        20 * 1024 * 1024,
+		"used.crit":        30 * 1024 * 1024,
+		"free_space.value": 1024,
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, values)
+	}
+	for path, v := range expected {
+		if values[path] != v {
+			t.Errorf("expected %s to be %v, got %v", path, v, values[path])
+		}
+	}
+}
+
+func TestParseNagiosPerfdata(t *testing.T) {
+	input := strings.Join([]string{
+		"HTTP OK: 200 in 0.5 seconds | time=500ms;1000;2000;0 'response size'=2KB;;;0;4",
+		"long text about the check",
+		"more text | 'it''s'=50%;80;90 mem=1MB",
+	}, "\n")
+	metrics, err := parseNagiosPerfdata(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	expectCmdMetrics(t, metrics, map[string]float64{
+		"time.value":          0.5,
+		"time.warn":           1,
+		"time.crit":           2,
+		"time.min":            0,
+		"response_size.value": 2048,
+		"response_size.min":   0,
+		"response_size.max":   4096,
+		"it_s.value":          50,
+		"it_s.warn":           80,
+		"it_s.crit":           90,
+		"mem.value":           1024 * 1024,
+	})
+}
+
+func TestParseNagiosThreshold(t *testing.T) {
+	cases := []struct {
+		threshold string
+		value     float64
+		ok        bool
+	}{
+		{"20", 20, true},
+		{"10:20", 20, true},
+		{"~:20", 20, true},
+		{"@10:", 10, true},
+		{"@10:20", 20, true},
+		{"", 0, false},
+		{"~:", 0, false},
+		{"abc", 0, false},
+	}
+	for _, c := range cases {
+		v, ok := parseNagiosThreshold(c.threshold)
+		if v != c.value || ok != c.ok {
+			t.Errorf("expected %q to be %v (%v), got %v (%v)", c.threshold, c.value, c.ok, v, ok)
+		}
+	}
+}
+
+func TestNagiosStatus(t *testing.T) {
+	cases := map[int]int{0: 0, 1: 1, 2: 2, 3: 3, 4: 3, 127: 3, -1: 3}
+	for exitcode, expected := range cases {
+		if status := nagiosStatus(exitcode); status != expected {
+			t.Errorf("expected exit code %d to be status %d, got %d", exitcode, expected, status)
+		}
+	}
+}
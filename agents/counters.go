@@ -0,0 +1,73 @@
+package agents
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/sink"
+)
+
+// counterTracker remembers the previous sample of monotonic counters so that
+// agents can report the increase, and rate of increase, since their last tick.
+type counterTracker struct {
+	lock sync.Mutex
+	prev map[string]counterSample
+}
+
+type counterSample struct {
+	value uint64
+	at    time.Time
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{
+		lock: sync.Mutex{},
+		prev: make(map[string]counterSample),
+	}
+}
+
+// delta stores the current value of the counter and returns the increase and
+// the number of seconds elapsed since the previous sample. The boolean is
+// false when there is no previous sample, or when the counter has gone
+// backwards. That is treated as a reset, since there is no reliable way to
+// tell the width of a kernel counter. A counter which wraps, such as an
+// unsigned long on a 32 bit kernel, loses the increase for one tick.
+func (t *counterTracker) delta(key string, current uint64, now time.Time) (uint64, float64, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	prev, ok := t.prev[key]
+	t.prev[key] = counterSample{value: current, at: now}
+	if !ok || current < prev.value {
+		return 0, 0, false
+	}
+	return current - prev.value, now.Sub(prev.at).Seconds(), true
+}
+
+// retain forgets the previous samples of any counters for which keep returns
+// false. Agents use this to drop the counters of things that have gone away, so
+// that the tracker doesn't grow forever.
+func (t *counterTracker) retain(keep func(key string) bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for key := range t.prev {
+		if !keep(key) {
+			delete(t.prev, key)
+		}
+	}
+}
+
+// count reports the increase in the counter since the previous tick to the
+// sink. If ratePath is not empty, the per-second rate of increase is also
+// reported as a gauge at that path. Nothing is reported on the first tick, or
+// when the counter has been reset.
+func (t *counterTracker) count(s sink.Sink, path string, ratePath string, current uint64, tags sink.Tags) {
+	d, elapsed, ok := t.delta(sink.ExpandPath(path, tags), current, time.Now())
+	if !ok {
+		return
+	}
+	s.Count(path, d, tags)
+	if ratePath != "" && elapsed > 0 {
+		s.Gauge(ratePath, float64(d)/elapsed, tags)
+	}
+}
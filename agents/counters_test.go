@@ -0,0 +1,43 @@
+package agents
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCounterTrackerDelta(t *testing.T) {
+	c := newCounterTracker()
+	now := time.Now()
+
+	if _, _, ok := c.delta("a", 100, now); ok {
+		t.Error("expected no delta for the first sample")
+	}
+	d, elapsed, ok := c.delta("a", 150, now.Add(2*time.Second))
+	if !ok || d != 50 || elapsed != 2 {
+		t.Errorf("expected a delta of 50 over 2 seconds, got %d over %v (%v)", d, elapsed, ok)
+	}
+
+	// a counter that goes backwards has been reset, even if it may have
+	// wrapped at 32 bits
+	c.delta("a", math.MaxUint32-10, now.Add(3*time.Second))
+	if d, _, ok := c.delta("a", 5, now.Add(4*time.Second)); ok {
+		t.Errorf("expected nothing for a reset counter, got %d", d)
+	}
+	if d, _, ok := c.delta("a", 25, now.Add(5*time.Second)); !ok || d != 20 {
+		t.Errorf("expected a delta of 20 after the reset, got %d (%v)", d, ok)
+	}
+}
+
+func TestCounterTrackerCount(t *testing.T) {
+	c := newCounterTracker()
+	s := newTestSink()
+
+	c.count(s, "a.{x}.total", "a.{x}.rate", 10, map[string]string{"x": "y"})
+	s.expectMissing(t, "a.y.total")
+	c.count(s, "a.{x}.total", "a.{x}.rate", 30, map[string]string{"x": "y"})
+	s.expect(t, "a.y.total", 20)
+	if m := s.get(t, "a.y.rate"); m.kind != "gauge" || m.value <= 0 {
+		t.Errorf("expected a positive rate gauge, got %+v", m)
+	}
+}
@@ -3,12 +3,14 @@ package agents
 import (
 	"fmt"
 	"runtime"
+	"strconv"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type cpuAgent struct {
@@ -34,7 +36,7 @@ func (a *cpuAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *cpuAgent) Tick(s sink.Sink) error {
+func (a *cpuAgent) Tick(ctx context.Context, s sink.Sink) error {
 
 	now := time.Now()
 	cpuTimesSet, err := cpu.Times(true)
@@ -52,8 +54,8 @@ func (a *cpuAgent) Tick(s sink.Sink) error {
 		// if we have a previous total for this cpu
 		if a.hasPrevCPU && len(a.prevCPUTotals) > i {
 			percent := a.calculateCPUPercent(a.prevCPUTotals[i], totals[i], a.prevCPUBusys[i], busys[i])
-			subpath := fmt.Sprintf("%s.%v.cpu_percent", a.config.Path, i)
-			s.Gauge(subpath, percent)
+			subpath := fmt.Sprintf("%s.{cpu}.cpu_percent", a.config.Path)
+			s.Gauge(subpath, percent, sink.Tags{"cpu": strconv.Itoa(i)})
 		}
 	}
 
@@ -11,16 +11,19 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type diskAgent struct {
 	diskAgentSettings
 	config   conf.SpoonConfigAgent
 	settings map[string]string
+	counters *counterTracker
 }
 
 type diskAgentSettings struct {
 	DeviceRegex string `json:"device_regex"`
+	Rates       bool   `json:"rates"`
 }
 
 func NewDiskAgent(config *conf.SpoonConfigAgent) (Agent, error) {
@@ -31,6 +34,7 @@ func NewDiskAgent(config *conf.SpoonConfigAgent) (Agent, error) {
 	return &diskAgent{
 		diskAgentSettings: s,
 		config:            (*config),
+		counters:          newCounterTracker(),
 	}, nil
 }
 
@@ -38,7 +42,7 @@ func (a *diskAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *diskAgent) Tick(s sink.Sink) error {
+func (a *diskAgent) Tick(ctx context.Context, s sink.Sink) error {
 
 	// fetch all the physical disk partitions. the boolean indicates whether
 	// non-physical partitions should be returned too.
@@ -55,15 +59,16 @@ func (a *diskAgent) Tick(s sink.Sink) error {
 			usage, uerr := disk.Usage(p.Mountpoint)
 			if uerr == nil {
 				log.Printf("Outputting Usage for %v because it matched device_regex", p.Device)
-				prefixPath := fmt.Sprintf("%s.%s", a.config.Path, a.formatDeviceName(p.Device))
+				prefixPath := fmt.Sprintf("%s.{device}", a.config.Path)
+				tags := sink.Tags{"device": a.formatDeviceName(p.Device)}
 
-				s.Gauge(fmt.Sprintf("%s.total_bytes", prefixPath), float64(usage.Total))
-				s.Gauge(fmt.Sprintf("%s.free_bytes", prefixPath), float64(usage.Free))
-				s.Gauge(fmt.Sprintf("%s.used_bytes", prefixPath), float64(usage.Used))
-				s.Gauge(fmt.Sprintf("%s.used_percent", prefixPath), float64(usage.UsedPercent))
-				s.Gauge(fmt.Sprintf("%s.inode_free_count", prefixPath), float64(usage.InodesFree))
-				s.Gauge(fmt.Sprintf("%s.inode_used_count", prefixPath), float64(usage.InodesUsed))
-				s.Gauge(fmt.Sprintf("%s.inode_used_percent", prefixPath), float64(usage.InodesUsedPercent))
+				s.Gauge(fmt.Sprintf("%s.total_bytes", prefixPath), float64(usage.Total), tags)
+				s.Gauge(fmt.Sprintf("%s.free_bytes", prefixPath), float64(usage.Free), tags)
+				s.Gauge(fmt.Sprintf("%s.used_bytes", prefixPath), float64(usage.Used), tags)
+				s.Gauge(fmt.Sprintf("%s.used_percent", prefixPath), float64(usage.UsedPercent), tags)
+				s.Gauge(fmt.Sprintf("%s.inode_free_count", prefixPath), float64(usage.InodesFree), tags)
+				s.Gauge(fmt.Sprintf("%s.inode_used_count", prefixPath), float64(usage.InodesUsed), tags)
+				s.Gauge(fmt.Sprintf("%s.inode_used_percent", prefixPath), float64(usage.InodesUsedPercent), tags)
 
 			} else {
 				log.Printf("Fetching usage for disk %v failed: %v", p.Mountpoint, uerr.Error())
@@ -86,13 +91,13 @@ func (a *diskAgent) Tick(s sink.Sink) error {
 			}
 
 			log.Printf("Outputting IO Counters for %v because it matched device_regex", deviceName)
-			prefixPath := fmt.Sprintf("%s.%s", a.config.Path, a.formatDeviceName(deviceName))
+			prefixPath := fmt.Sprintf("%s.{device}", a.config.Path)
+			tags := sink.Tags{"device": a.formatDeviceName(deviceName)}
 
-			s.Gauge(fmt.Sprintf("%s.read_count", prefixPath), float64(iostat.ReadCount))
-			s.Gauge(fmt.Sprintf("%s.write_count", prefixPath), float64(iostat.WriteCount))
-			s.Gauge(fmt.Sprintf("%s.read_bytes", prefixPath), float64(iostat.ReadBytes))
-			s.Gauge(fmt.Sprintf("%s.write_bytes", prefixPath), float64(iostat.WriteBytes))
-			s.Gauge(fmt.Sprintf("%s.read_count", prefixPath), float64(iostat.ReadCount))
+			a.counters.count(s, prefixPath+".read_count_delta", a.ratePath(prefixPath, "read_iops"), iostat.ReadCount, tags)
+			a.counters.count(s, prefixPath+".write_count_delta", a.ratePath(prefixPath, "write_iops"), iostat.WriteCount, tags)
+			a.counters.count(s, prefixPath+".read_bytes_delta", a.ratePath(prefixPath, "read_bytes_per_sec"), iostat.ReadBytes, tags)
+			a.counters.count(s, prefixPath+".write_bytes_delta", a.ratePath(prefixPath, "write_bytes_per_sec"), iostat.WriteBytes, tags)
 		}
 
 	} else {
@@ -102,6 +107,15 @@ func (a *diskAgent) Tick(s sink.Sink) error {
 	return nil
 }
 
+// ratePath returns the path for a rate metric, or an empty string if rates
+// are not enabled
+func (a *diskAgent) ratePath(prefixPath, name string) string {
+	if !a.Rates {
+		return ""
+	}
+	return prefixPath + "." + name
+}
+
 func (a *diskAgent) formatDeviceName(device string) string {
 	// first replace all forward slashes with -
 	device = strings.Replace(device, "/", "_", -1)
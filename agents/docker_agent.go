@@ -1,11 +1,14 @@
 package agents
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"golang.org/x/net/context"
@@ -20,22 +23,68 @@ import (
 
 type dockerAgent struct {
 	dockerAgentSettings
-	config conf.SpoonConfigAgent
+	config       conf.SpoonConfigAgent
+	counters     *counterTracker
+	nameTemplate *template.Template
+
+	// the client is kept between ticks and only rebuilt if talking to the
+	// docker daemon fails
+	clientLock sync.Mutex
+	client     *client.Client
 }
 
 type dockerAgentSettings struct {
 	ContainerFilters map[string]string
+	// NameTemplate builds the container name from a text/template over the
+	// container, for example "{{.Labels.com.docker.compose.service}}"
+	NameTemplate string `json:"name_template"`
+	// Aggregate combines the metrics of containers with the same name
+	Aggregate bool `json:"aggregate"`
+}
+
+// dockerTemplateData is the data available to the name template
+type dockerTemplateData struct {
+	ID     string
+	Name   string
+	Image  string
+	Labels map[string]string
 }
 
+// dockerMetric is a single value for a container. Network metrics carry the
+// interface they belong to.
+type dockerMetric struct {
+	path  string
+	iface string
+	value float64
+	count bool
+}
+
+// labelFieldRegex matches label lookups in the name template. Label keys are
+// usually dotted, which text/template would treat as nested fields.
+var labelFieldRegex = regexp.MustCompile(`\.Labels\.([a-zA-Z0-9_\-\.]+)`)
+
 func NewDockerAgent(config *conf.SpoonConfigAgent) (Agent, error) {
 	s := dockerAgentSettings{}
 	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
 		return nil, fmt.Errorf("failed to parse settings: %s", err)
 	}
 
+	var nameTemplate *template.Template
+	if s.NameTemplate != "" {
+		source := labelFieldRegex.ReplaceAllString(s.NameTemplate, `(index .Labels "$1")`)
+		t, err := template.New("name").Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("dockerAgent has invalid name_template: %s", err)
+		}
+		nameTemplate = t
+	}
+
 	agent := &dockerAgent{
 		dockerAgentSettings: s,
 		config:              (*config),
+		counters:            newCounterTracker(),
+		nameTemplate:        nameTemplate,
+		clientLock:          sync.Mutex{},
 	}
 	return agent, nil
 }
@@ -44,14 +93,38 @@ func (a *dockerAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *dockerAgent) Tick(s sink.Sink) error {
-	ctx := context.Background()
-	cli, err := client.NewEnvClient()
+// getClient returns the current docker client, creating one if there isn't
+// one yet or the previous one was discarded.
+func (a *dockerAgent) getClient(ctx context.Context) (*client.Client, error) {
+	a.clientLock.Lock()
+	defer a.clientLock.Unlock()
+	if a.client == nil {
+		cli, err := client.NewEnvClient()
+		if err != nil {
+			return nil, err
+		}
+		cli.NegotiateAPIVersion(ctx)
+		a.client = cli
+	}
+	return a.client, nil
+}
+
+// resetClient discards the client so that the next tick reconnects, and picks
+// up any change in the api version of the daemon.
+func (a *dockerAgent) resetClient(cli *client.Client) {
+	a.clientLock.Lock()
+	defer a.clientLock.Unlock()
+	if a.client == cli {
+		a.client.Close()
+		a.client = nil
+	}
+}
+
+func (a *dockerAgent) Tick(ctx context.Context, s sink.Sink) error {
+	cli, err := a.getClient(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to setup docker client: %s", err)
 	}
-	cli.NegotiateAPIVersion(ctx)
-	defer cli.Close()
 
 	filters := filters.NewArgs()
 	filters.Add("status", "running")
@@ -60,51 +133,203 @@ func (a *dockerAgent) Tick(s sink.Sink) error {
 	}
 	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{Filters: filters})
 	if err != nil {
+		a.resetClient(cli)
 		return fmt.Errorf("failed to list containers: %s", err)
 	}
 
+	a.forgetRemovedContainers(containers)
+
+	lock := sync.Mutex{}
+	samples := make(map[string][][]dockerMetric)
+	names := []string{}
 	wg := sync.WaitGroup{}
 	for _, c := range containers {
-
-		// name comes from container name itself
-		name := strings.Replace(strings.Trim(c.Names[0], "/"), ".", "_", -1)
+		name := a.containerName(c)
 		id := c.ID
 		uptime := time.Now().Sub(time.Unix(c.Created, 0))
 		wg.Add(1)
 		go func() {
-			a.doStatsForContainer(s, cli, id, name, uptime)
-			wg.Done()
+			defer wg.Done()
+			metrics := a.doStatsForContainer(ctx, cli, id, uptime)
+			if metrics == nil {
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if _, ok := samples[name]; !ok {
+				names = append(names, name)
+			}
+			samples[name] = append(samples[name], metrics)
 		}()
 	}
 	wg.Wait()
+
+	prefixPath := fmt.Sprintf("%s.{container}", a.config.Path)
+	for _, name := range names {
+		if a.Aggregate {
+			s.Gauge(prefixPath+".instances", len(samples[name]), sink.Tags{"container": name})
+			a.report(s, prefixPath, name, aggregateDockerMetrics(samples[name]))
+			continue
+		}
+		for _, metrics := range samples[name] {
+			a.report(s, prefixPath, name, metrics)
+		}
+	}
 	return nil
 }
 
-func (a *dockerAgent) doStatsForContainer(s sink.Sink, cli *client.Client, cid, cname string, uptime time.Duration) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(a.config.Interval)*time.Second)
+// forgetRemovedContainers drops the counters of containers that are no longer
+// running, since container ids are never reused.
+func (a *dockerAgent) forgetRemovedContainers(containers []types.Container) {
+	running := make(map[string]bool, len(containers))
+	for _, c := range containers {
+		running[c.ID] = true
+	}
+	a.counters.retain(func(key string) bool {
+		return running[strings.SplitN(key, ".", 2)[0]]
+	})
+}
+
+// containerName builds the name used for the container in metric paths. This
+// comes from the name template if one is configured, falling back to the
+// container name itself.
+func (a *dockerAgent) containerName(c types.Container) string {
+	if a.nameTemplate != nil {
+		buf := new(bytes.Buffer)
+		err := a.nameTemplate.Execute(buf, dockerTemplateData{
+			ID:     c.ID,
+			Name:   strings.Trim(c.Names[0], "/"),
+			Image:  c.Image,
+			Labels: c.Labels,
+		})
+		if err != nil {
+			log.Printf("failed to render name template for container %s: %s", c.ID, err)
+		} else if name := sanitizePathPart(strings.TrimSpace(buf.String())); strings.Trim(name, "_") != "" {
+			return name
+		}
+	}
+	return strings.Replace(strings.Trim(c.Names[0], "/"), ".", "_", -1)
+}
+
+// report sends the metrics for a container, or group of containers, to the sink
+func (a *dockerAgent) report(s sink.Sink, prefixPath string, name string, metrics []dockerMetric) {
+	for _, m := range metrics {
+		path := prefixPath + "." + m.path
+		tags := sink.Tags{"container": name}
+		if m.iface != "" {
+			path = prefixPath + ".networks.{interface}." + m.path
+			tags["interface"] = m.iface
+		}
+		if m.count {
+			s.Count(path, uint64(m.value), tags)
+		} else {
+			s.Gauge(path, m.value, tags)
+		}
+	}
+}
+
+// doStatsForContainer fetches the stats of a single container. Counters are
+// converted into the increase since the previous tick here, keyed by the
+// container id, so that they can be safely summed across replicas.
+func (a *dockerAgent) doStatsForContainer(ctx context.Context, cli *client.Client, cid string, uptime time.Duration) []dockerMetric {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(a.config.Interval)*time.Second)
+	defer cancel()
 	data, err := cli.ContainerStats(ctx, cid, false)
 	if err != nil {
 		log.Printf("unable to pull stats for container %s: %s", cid, err)
-		return
+		return nil
 	}
 	defer data.Body.Close()
-	defer cancel()
 	stats := new(types.StatsJSON)
 	if err = json.NewDecoder(data.Body).Decode(stats); err != nil {
 		log.Printf("failed to parse stats from container %s: %s", cid, err)
-		return
+		return nil
+	}
+
+	now := time.Now()
+	metrics := []dockerMetric{}
+	gauge := func(path string, value float64) {
+		metrics = append(metrics, dockerMetric{path: path, value: value})
+	}
+	count := func(path string, iface string, current uint64) {
+		if d, _, ok := a.counters.delta(cid+"."+iface+"."+path, current, now); ok {
+			metrics = append(metrics, dockerMetric{path: path, iface: iface, value: float64(d), count: true})
+		}
 	}
 
-	s.Gauge(fmt.Sprintf("%s.%s.uptime_seconds", a.config.Path, cname), uptime.Seconds())
-	s.Gauge(fmt.Sprintf("%s.%s.cpus.usage_percent", a.config.Path, cname), calculateCPUPercent(stats))
-	s.Gauge(fmt.Sprintf("%s.%s.memory.usage_bytes", a.config.Path, cname), calculateMemoryBytes(stats))
-	s.Gauge(fmt.Sprintf("%s.%s.memory.usage_percent", a.config.Path, cname), calculateMemoryUsage(stats))
+	gauge("uptime_seconds", uptime.Seconds())
+	gauge("cpus.usage_percent", calculateCPUPercent(stats))
+	count("cpus.throttled_periods", "", stats.CPUStats.ThrottlingData.ThrottledPeriods)
+	count("cpus.throttled_time_ns", "", stats.CPUStats.ThrottlingData.ThrottledTime)
+	gauge("memory.usage_bytes", float64(calculateMemoryBytes(stats)))
+	gauge("memory.usage_percent", calculateMemoryUsage(stats))
+	cache, rss := calculateMemoryCacheAndRSS(stats)
+	gauge("memory.cache_bytes", float64(cache))
+	gauge("memory.rss_bytes", float64(rss))
+	gauge("pids.current", float64(stats.PidsStats.Current))
+
+	readBytes, writeBytes := calculateBlkioBytes(stats)
+	count("blkio.read_bytes", "", readBytes)
+	count("blkio.write_bytes", "", writeBytes)
 
 	for iface, nstats := range stats.Networks {
-		s.Gauge(fmt.Sprintf("%s.%s.networks.%s.rx_bytes", a.config.Path, cname, iface), float64(nstats.RxBytes))
-		s.Gauge(fmt.Sprintf("%s.%s.networks.%s.tx_bytes", a.config.Path, cname, iface), float64(nstats.TxBytes))
-		// TODO: can do a lot more here with packets/dropped/errors
+		count("rx_bytes_delta", iface, nstats.RxBytes)
+		count("tx_bytes_delta", iface, nstats.TxBytes)
+		count("rx_packets_delta", iface, nstats.RxPackets)
+		count("tx_packets_delta", iface, nstats.TxPackets)
+		count("rx_errors_delta", iface, nstats.RxErrors)
+		count("tx_errors_delta", iface, nstats.TxErrors)
+		count("rx_dropped_delta", iface, nstats.RxDropped)
+		count("tx_dropped_delta", iface, nstats.TxDropped)
+	}
+
+	// the restart count and health are only available by inspecting the
+	// container
+	info, err := cli.ContainerInspect(ctx, cid)
+	if err != nil {
+		log.Printf("unable to inspect container %s: %s", cid, err)
+		return metrics
 	}
+	gauge("restart_count", float64(info.RestartCount))
+	if info.State != nil && info.State.Health != nil {
+		healthy := 0.0
+		if info.State.Health.Status == types.Healthy {
+			healthy = 1
+		}
+		gauge("healthy", healthy)
+		gauge("health_failing_streak", float64(info.State.Health.FailingStreak))
+	}
+	return metrics
+}
+
+// aggregateDockerMetrics combines the metrics of containers that share a name.
+// Counts are summed, while gauges are reported as both the sum and, with an
+// "_avg" suffix, the average across the containers that reported them.
+func aggregateDockerMetrics(samples [][]dockerMetric) []dockerMetric {
+	output := []dockerMetric{}
+	index := map[string]int{}
+	seen := map[string]int{}
+	for _, metrics := range samples {
+		for _, m := range metrics {
+			key := m.iface + "." + m.path
+			if i, ok := index[key]; ok {
+				output[i].value += m.value
+			} else {
+				index[key] = len(output)
+				output = append(output, m)
+			}
+			seen[key]++
+		}
+	}
+	for _, m := range output {
+		if !m.count {
+			avg := m
+			avg.path += "_avg"
+			avg.value /= float64(seen[m.iface+"."+m.path])
+			output = append(output, avg)
+		}
+	}
+	return output
 }
 
 func calculateCPUPercent(
@@ -128,3 +353,31 @@ func calculateMemoryUsage(stats *types.StatsJSON) float64 {
 	}
 	return 0
 }
+
+// calculateMemoryCacheAndRSS returns the page cache and anonymous memory used
+// by the container. These have different names under cgroup v1 and v2.
+func calculateMemoryCacheAndRSS(stats *types.StatsJSON) (uint64, uint64) {
+	cache, ok := stats.MemoryStats.Stats["cache"]
+	if !ok {
+		cache = stats.MemoryStats.Stats["file"]
+	}
+	rss, ok := stats.MemoryStats.Stats["rss"]
+	if !ok {
+		rss = stats.MemoryStats.Stats["anon"]
+	}
+	return cache, rss
+}
+
+// calculateBlkioBytes sums the bytes read and written across all devices
+func calculateBlkioBytes(stats *types.StatsJSON) (uint64, uint64) {
+	var readBytes, writeBytes uint64
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			readBytes += entry.Value
+		case "write":
+			writeBytes += entry.Value
+		}
+	}
+	return readBytes, writeBytes
+}
@@ -0,0 +1,183 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeDocker serves canned container list and stats responses on a unix
+// socket. The received bytes of each container go up by 100 on every stats
+// request so that the counters have something to report.
+type fakeDocker struct {
+	lock       sync.Mutex
+	containers []map[string]interface{}
+	statsCalls map[string]int
+}
+
+var dockerVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	path := dockerVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/_ping":
+		w.Header().Set("Api-Version", "1.25")
+		w.Write([]byte("OK"))
+	case path == "/containers/json":
+		json.NewEncoder(w).Encode(d.containers)
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "stats":
+		d.statsCalls[parts[1]]++
+		template, err := ioutil.ReadFile("testdata/docker/stats.json")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, string(template), 1000+100*d.statsCalls[parts[1]])
+	case len(parts) == 3 && parts[0] == "containers" && parts[2] == "json":
+		w.Write([]byte(`{"Id": "` + parts[1] + `", "RestartCount": 2, "State": {"Health": {"Status": "healthy", "FailingStreak": 0}}}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startFakeDocker points the docker client at a fake daemon on a temporary
+// unix socket. The returned function stops it.
+func startFakeDocker(t *testing.T) (*fakeDocker, func()) {
+	t.Helper()
+	data, err := ioutil.ReadFile("testdata/docker/containers.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &fakeDocker{statsCalls: map[string]int{}}
+	if err := json.Unmarshal(data, &d.containers); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(d)
+	server.Listener = listener
+	server.Start()
+
+	previous, hadPrevious := os.LookupEnv("DOCKER_HOST")
+	os.Setenv("DOCKER_HOST", "unix://"+socket)
+	return d, func() {
+		if hadPrevious {
+			os.Setenv("DOCKER_HOST", previous)
+		} else {
+			os.Unsetenv("DOCKER_HOST")
+		}
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDockerAgent(t *testing.T) {
+	_, stop := startFakeDocker(t)
+	defer stop()
+
+	agent, err := NewDockerAgent(testAgentConfig(t, "docker", "docker", map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "docker.web_1.memory.usage_bytes", 1048576)
+	s.expect(t, "docker.web_1.memory.usage_percent", 25)
+	s.expect(t, "docker.web_1.memory.cache_bytes", 1024)
+	s.expect(t, "docker.web_1.memory.rss_bytes", 2048)
+	s.expect(t, "docker.web_1.cpus.usage_percent", 20)
+	s.expect(t, "docker.web_1.pids.current", 4)
+	s.expect(t, "docker.web_1.restart_count", 2)
+	s.expect(t, "docker.web_1.healthy", 1)
+	s.expect(t, "docker.web_1.blkio.read_bytes", 0)
+	s.expect(t, "docker.web_1.networks.eth0.rx_bytes_delta", 100)
+	s.expect(t, "docker.web_1.networks.eth0.tx_bytes_delta", 0)
+	if m := s.get(t, "docker.web_1.networks.eth0.rx_bytes_delta"); m.kind != "count" || m.tags["interface"] != "eth0" {
+		t.Errorf("expected rx_bytes_delta to be a count tagged with the interface, got %+v", m)
+	}
+	s.expect(t, "docker.web_2.pids.current", 4)
+}
+
+func TestDockerAgentAggregate(t *testing.T) {
+	_, stop := startFakeDocker(t)
+	defer stop()
+
+	agent, err := NewDockerAgent(testAgentConfig(t, "docker", "docker", map[string]interface{}{
+		"name_template": "{{.Labels.service}}",
+		"aggregate":     true,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "docker.web.instances", 2)
+	s.expect(t, "docker.web.pids.current", 8)
+	s.expect(t, "docker.web.pids.current_avg", 4)
+	s.expect(t, "docker.web.networks.eth0.rx_bytes_delta", 200)
+}
+
+func TestDockerAgentForgetsRemovedContainers(t *testing.T) {
+	d, stop := startFakeDocker(t)
+	defer stop()
+
+	agent, err := NewDockerAgent(testAgentConfig(t, "docker", "docker", map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters := agent.(*dockerAgent).counters
+	if err := agent.Tick(context.Background(), newTestSink()); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	if _, ok := counters.prev["bbb222.eth0.rx_bytes_delta"]; !ok {
+		t.Fatal("expected the second container to have counters")
+	}
+
+	d.lock.Lock()
+	d.containers = d.containers[:1]
+	d.lock.Unlock()
+	if err := agent.Tick(context.Background(), newTestSink()); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	for key := range counters.prev {
+		if strings.HasPrefix(key, "bbb222.") {
+			t.Errorf("expected counter %s to be forgotten", key)
+		}
+	}
+	if _, ok := counters.prev["aaa111.eth0.rx_bytes_delta"]; !ok {
+		t.Error("expected the remaining container counters to be kept")
+	}
+}
@@ -0,0 +1,118 @@
+package agents
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+)
+
+// testAgentConfig builds an enabled agent config with the given settings
+func testAgentConfig(t *testing.T, agentType string, path string, settings interface{}) *conf.SpoonConfigAgent {
+	t.Helper()
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &conf.SpoonConfigAgent{}
+	cfg.Enabled = true
+	cfg.Type = agentType
+	cfg.Path = path
+	cfg.Interval = 5
+	cfg.SettingsRaw = raw
+	return cfg
+}
+
+// testMetric is a single metric recorded by the testSink
+type testMetric struct {
+	kind  string
+	value float64
+	tags  sink.Tags
+}
+
+// testSink records the last value of each metric, keyed by its path with any
+// tag segments expanded
+type testSink struct {
+	lock    sync.Mutex
+	metrics map[string]testMetric
+}
+
+func newTestSink() *testSink {
+	return &testSink{metrics: map[string]testMetric{}}
+}
+
+func (s *testSink) record(kind string, path string, value interface{}, tags []sink.Tags) {
+	var t sink.Tags
+	if len(tags) > 0 {
+		t = tags[0]
+	}
+	v, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if err != nil {
+		panic(fmt.Sprintf("%s value %v is not a number", path, value))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metrics[sink.ExpandPath(path, t)] = testMetric{kind: kind, value: v, tags: t}
+}
+
+func (s *testSink) Gauge(bucket string, value interface{}, tags ...sink.Tags) {
+	s.record("gauge", bucket, value, tags)
+}
+
+func (s *testSink) Count(bucket string, n interface{}, tags ...sink.Tags) {
+	s.record("count", bucket, n, tags)
+}
+
+func (s *testSink) Increment(bucket string, tags ...sink.Tags) {
+	s.record("count", bucket, 1, tags)
+}
+
+func (s *testSink) Timing(bucket string, value interface{}, tags ...sink.Tags) {
+	s.record("timing", bucket, value, tags)
+}
+
+func (s *testSink) Set(bucket string, member string, tags ...sink.Tags) {
+	s.record("set", bucket, 1, tags)
+}
+
+func (s *testSink) Histogram(bucket string, value interface{}, tags ...sink.Tags) {
+	s.record("histogram", bucket, value, tags)
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
+
+// get returns the recorded metric, failing the test if it is missing
+func (s *testSink) get(t *testing.T, path string) testMetric {
+	t.Helper()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	m, ok := s.metrics[path]
+	if !ok {
+		t.Fatalf("expected metric %s to be reported", path)
+	}
+	return m
+}
+
+// expect checks the value of the recorded metric
+func (s *testSink) expect(t *testing.T, path string, value float64) {
+	t.Helper()
+	if m := s.get(t, path); m.value != value {
+		t.Errorf("expected %s to be %v, got %v", path, value, m.value)
+	}
+}
+
+// expectMissing checks that the metric was not recorded
+func (s *testSink) expectMissing(t *testing.T, path string) {
+	t.Helper()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if m, ok := s.metrics[path]; ok {
+		t.Errorf("expected %s to not be reported, got %v", path, m.value)
+	}
+}
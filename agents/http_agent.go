@@ -0,0 +1,227 @@
+package agents
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/constants"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// httpAgentMaxBody is the most of a response body that is read for matching
+const httpAgentMaxBody = 10 * 1024 * 1024
+
+type httpAgent struct {
+	httpAgentSettings
+	config  conf.SpoonConfigAgent
+	targets []*httpTarget
+}
+
+type httpAgentSettings struct {
+	Targets []httpTargetSettings `json:"targets"`
+}
+
+type httpTargetSettings struct {
+	Name    string            `json:"name"`
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// ExpectedStatus is the status code needed for success. If it is not set,
+	// any 2xx or 3xx status is a success.
+	ExpectedStatus int    `json:"expected_status"`
+	BodyRegex      string `json:"body_regex"`
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+	// Timeout is in seconds and is capped at the interval of the agent
+	Timeout float64 `json:"timeout"`
+}
+
+type httpTarget struct {
+	httpTargetSettings
+	bodyRegex *regexp.Regexp
+	client    *http.Client
+	timeout   time.Duration
+}
+
+// httpTimings are the durations of each phase of a request
+type httpTimings struct {
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+	firstByte                 time.Time
+}
+
+func NewHTTPAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := httpAgentSettings{}
+	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %s", err)
+	}
+	if len(s.Targets) < 1 {
+		return nil, errors.New("httpAgent 'targets' setting must have at least one item")
+	}
+
+	interval := time.Duration(float64(config.Interval) * float64(time.Second))
+	validName := regexp.MustCompile("^" + constants.ValidPathPartRegex + "$")
+	targets := make([]*httpTarget, len(s.Targets))
+	for i, ts := range s.Targets {
+		if !validName.MatchString(ts.Name) {
+			return nil, fmt.Errorf("httpAgent target name '%s' is not a valid path segment", ts.Name)
+		}
+		if ts.Method == "" {
+			ts.Method = http.MethodGet
+		}
+		if _, err := http.NewRequest(ts.Method, ts.URL, nil); err != nil {
+			return nil, fmt.Errorf("httpAgent target '%s' is invalid: %s", ts.Name, err)
+		}
+		t := &httpTarget{httpTargetSettings: ts, timeout: interval}
+		if ts.Timeout < 0 {
+			return nil, fmt.Errorf("httpAgent target '%s' timeout must be positive", ts.Name)
+		} else if ts.Timeout > 0 && time.Duration(ts.Timeout*float64(time.Second)) < interval {
+			t.timeout = time.Duration(ts.Timeout * float64(time.Second))
+		}
+		if ts.BodyRegex != "" {
+			r, err := regexp.Compile(ts.BodyRegex)
+			if err != nil {
+				return nil, fmt.Errorf("httpAgent target '%s' has invalid body_regex: %s", ts.Name, err)
+			}
+			t.bodyRegex = r
+		}
+
+		// keep alives are disabled so that every check measures the whole
+		// connection, and redirects are reported rather than followed
+		t.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:             http.ProxyFromEnvironment,
+				DisableKeepAlives: true,
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: ts.InsecureSkipVerify},
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		targets[i] = t
+	}
+
+	return &httpAgent{
+		httpAgentSettings: s,
+		config:            *config,
+		targets:           targets,
+	}, nil
+}
+
+func (a *httpAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *httpAgent) Tick(ctx context.Context, s sink.Sink) error {
+	wg := sync.WaitGroup{}
+	for _, t := range a.targets {
+		wg.Add(1)
+		go func(t *httpTarget) {
+			defer wg.Done()
+			a.doTarget(ctx, s, t)
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (a *httpAgent) doTarget(ctx context.Context, s sink.Sink, t *httpTarget) {
+	prefixPath := a.config.Path + ".{target}"
+	tags := sink.Tags{"target": t.Name}
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if t.Body != "" {
+		body = strings.NewReader(t.Body)
+	}
+	req, err := http.NewRequest(t.Method, t.URL, body)
+	if err != nil {
+		log.Printf("Failed to build request for %s: %s", t.URL, err)
+		s.Gauge(prefixPath+".success", 0, tags)
+		return
+	}
+	for k, v := range t.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	timings := &httpTimings{}
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { timings.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { timings.dnsDone = time.Now() },
+		ConnectStart:         func(string, string) { timings.connectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { timings.connectDone = time.Now() },
+		TLSHandshakeStart:    func() { timings.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { timings.tlsDone = time.Now() },
+		GotFirstResponseByte: func() { timings.firstByte = time.Now() },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, trace))
+
+	start := time.Now()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		log.Printf("Request to %s failed: %s", t.URL, err)
+		s.Gauge(prefixPath+".success", 0, tags)
+		return
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpAgentMaxBody))
+	total := time.Since(start)
+	if err != nil {
+		log.Printf("Failed to read response from %s: %s", t.URL, err)
+	}
+
+	success := err == nil
+	if t.ExpectedStatus != 0 {
+		success = success && resp.StatusCode == t.ExpectedStatus
+	} else {
+		success = success && resp.StatusCode >= 200 && resp.StatusCode < 400
+	}
+	if t.bodyRegex != nil {
+		success = success && t.bodyRegex.Match(data)
+	}
+
+	successValue := 0
+	if success {
+		successValue = 1
+	}
+	s.Gauge(prefixPath+".success", successValue, tags)
+	s.Gauge(prefixPath+".status_code", resp.StatusCode, tags)
+	s.Gauge(prefixPath+".body_bytes", len(data), tags)
+
+	reportPhase := func(name string, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			s.Timing(prefixPath+"."+name, to.Sub(from).Seconds(), tags)
+		}
+	}
+	reportPhase("dns_seconds", timings.dnsStart, timings.dnsDone)
+	reportPhase("connect_seconds", timings.connectStart, timings.connectDone)
+	reportPhase("tls_seconds", timings.tlsStart, timings.tlsDone)
+	reportPhase("ttfb_seconds", start, timings.firstByte)
+	s.Timing(prefixPath+".total_seconds", total.Seconds(), tags)
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		expiry := resp.TLS.PeerCertificates[0].NotAfter
+		s.Gauge(prefixPath+".cert_expiry_days", time.Until(expiry).Hours()/24, tags)
+	}
+}
@@ -0,0 +1,143 @@
+package agents
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// httpTestServer replies to /ok with a body, /created with a 201, /redirect
+// with a 302, /echo with the details of the request and /slow after a delay
+func httpTestServer(handler func(http.Handler) *httptest.Server) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("status: healthy"))
+	})
+	mux.HandleFunc("/created", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.Method + " " + r.Host + " " + r.Header.Get("X-Check") + " " + string(body)))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(2 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	return handler(mux)
+}
+
+func tickHTTPAgent(t *testing.T, targets []map[string]interface{}) *testSink {
+	t.Helper()
+	agent, err := NewHTTPAgent(testAgentConfig(t, "http", "http", map[string]interface{}{"targets": targets}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	return s
+}
+
+func TestHTTPAgentStatusMatching(t *testing.T) {
+	server := httpTestServer(httptest.NewServer)
+	defer server.Close()
+
+	s := tickHTTPAgent(t, []map[string]interface{}{
+		{"name": "ok", "url": server.URL + "/ok"},
+		{"name": "created", "url": server.URL + "/created", "expected_status": 201},
+		{"name": "not_created", "url": server.URL + "/ok", "expected_status": 201},
+		{"name": "redirect", "url": server.URL + "/redirect"},
+		{"name": "missing", "url": server.URL + "/missing"},
+	})
+
+	s.expect(t, "http.ok.success", 1)
+	s.expect(t, "http.ok.status_code", 200)
+	s.expect(t, "http.ok.body_bytes", 15)
+	s.get(t, "http.ok.total_seconds")
+	s.get(t, "http.ok.connect_seconds")
+	s.expectMissing(t, "http.ok.tls_seconds")
+	s.expectMissing(t, "http.ok.cert_expiry_days")
+
+	s.expect(t, "http.created.success", 1)
+	s.expect(t, "http.not_created.success", 0)
+	s.expect(t, "http.not_created.status_code", 200)
+
+	// redirects are reported rather than followed
+	s.expect(t, "http.redirect.success", 1)
+	s.expect(t, "http.redirect.status_code", 302)
+
+	s.expect(t, "http.missing.success", 0)
+	s.expect(t, "http.missing.status_code", 404)
+}
+
+func TestHTTPAgentBodyMatching(t *testing.T) {
+	server := httpTestServer(httptest.NewServer)
+	defer server.Close()
+
+	s := tickHTTPAgent(t, []map[string]interface{}{
+		{"name": "match", "url": server.URL + "/ok", "body_regex": "status: (healthy|ok)"},
+		{"name": "mismatch", "url": server.URL + "/ok", "body_regex": "status: ok"},
+		{
+			"name":       "echo",
+			"url":        server.URL + "/echo",
+			"method":     "POST",
+			"headers":    map[string]string{"Host": "example.com", "X-Check": "yes"},
+			"body":       "ping",
+			"body_regex": "^POST example.com yes ping$",
+		},
+	})
+
+	s.expect(t, "http.match.success", 1)
+	s.expect(t, "http.mismatch.success", 0)
+	s.expect(t, "http.mismatch.status_code", 200)
+	s.expect(t, "http.echo.success", 1)
+}
+
+func TestHTTPAgentTimeout(t *testing.T) {
+	server := httpTestServer(httptest.NewServer)
+	defer server.Close()
+
+	start := time.Now()
+	s := tickHTTPAgent(t, []map[string]interface{}{
+		{"name": "slow", "url": server.URL + "/slow", "timeout": 0.1},
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the request to time out quickly, took %s", elapsed)
+	}
+
+	s.expect(t, "http.slow.success", 0)
+	s.expectMissing(t, "http.slow.status_code")
+	s.expectMissing(t, "http.slow.total_seconds")
+}
+
+func TestHTTPAgentTLS(t *testing.T) {
+	server := httpTestServer(httptest.NewTLSServer)
+	defer server.Close()
+
+	s := tickHTTPAgent(t, []map[string]interface{}{
+		{"name": "insecure", "url": server.URL + "/ok", "insecure_skip_verify": true},
+		{"name": "verified", "url": server.URL + "/ok"},
+	})
+
+	s.expect(t, "http.insecure.success", 1)
+	s.get(t, "http.insecure.tls_seconds")
+	expected := time.Until(server.Certificate().NotAfter).Hours() / 24
+	if days := s.get(t, "http.insecure.cert_expiry_days").value; days < expected-1 || days > expected+1 {
+		t.Errorf("expected cert_expiry_days to be about %v, got %v", expected, days)
+	}
+
+	// the test certificate is self signed so it fails verification
+	s.expect(t, "http.verified.success", 0)
+	s.expectMissing(t, "http.verified.cert_expiry_days")
+}
@@ -0,0 +1,86 @@
+package agents
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/load"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type loadAgent struct {
+	config conf.SpoonConfigAgent
+}
+
+func NewLoadAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	return &loadAgent{config: (*config)}, nil
+}
+
+func (a *loadAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *loadAgent) Tick(ctx context.Context, s sink.Sink) error {
+
+	avg, err := load.Avg()
+	if err != nil {
+		return err
+	}
+	s.Gauge(a.config.Path+".load_1m", avg.Load1)
+	s.Gauge(a.config.Path+".load_5m", avg.Load5)
+	s.Gauge(a.config.Path+".load_15m", avg.Load15)
+
+	// on linux, count the processes in each state from /proc. otherwise fall
+	// back to what gopsutil can provide.
+	if counts, err := countProcessStates("/proc"); err == nil {
+		s.Gauge(a.config.Path+".procs_running", counts['R'])
+		s.Gauge(a.config.Path+".procs_blocked", counts['D'])
+		s.Gauge(a.config.Path+".procs_zombie", counts['Z'])
+		s.Gauge(a.config.Path+".procs_total", counts[0])
+		return nil
+	}
+
+	misc, err := load.Misc()
+	if err != nil {
+		return err
+	}
+	s.Gauge(a.config.Path+".procs_running", misc.ProcsRunning)
+	s.Gauge(a.config.Path+".procs_blocked", misc.ProcsBlocked)
+	return nil
+}
+
+// countProcessStates reads the state of every process from the given proc
+// directory. The returned map is keyed by the state character, like 'R' or
+// 'Z', and the total number of processes is stored under 0.
+func countProcessStates(procPath string) (map[byte]int, error) {
+	statFiles, err := filepath.Glob(filepath.Join(procPath, "[0-9]*", "stat"))
+	if err != nil {
+		return nil, err
+	}
+	if len(statFiles) == 0 {
+		return nil, os.ErrNotExist
+	}
+	counts := map[byte]int{}
+	for _, f := range statFiles {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			// the process may have exited since the glob
+			continue
+		}
+		// the state follows the command name, which is wrapped in brackets
+		// and may itself contain spaces or brackets
+		content := string(data)
+		i := strings.LastIndex(content, ")")
+		if i < 0 || i+2 >= len(content) {
+			continue
+		}
+		counts[content[i+2]]++
+		counts[0]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,31 @@
+package agents
+
+import (
+	"os"
+	"testing"
+)
+
+func TestCountProcessStates(t *testing.T) {
+	counts, err := countProcessStates("testdata/proc")
+	if err != nil {
+		t.Fatalf("failed to count: %s", err)
+	}
+
+	// command names may contain spaces and brackets, and the stat file which
+	// has been cut short is skipped
+	expected := map[byte]int{'R': 2, 'D': 1, 'Z': 1, 'S': 1, 0: 5}
+	if len(counts) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, counts)
+	}
+	for state, n := range expected {
+		if counts[state] != n {
+			t.Errorf("expected %d processes in state %q, got %d", n, state, counts[state])
+		}
+	}
+}
+
+func TestCountProcessStatesMissing(t *testing.T) {
+	if _, err := countProcessStates("testdata/proc/missing"); err != os.ErrNotExist {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
@@ -0,0 +1,367 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/constants"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type logtailAgent struct {
+	logtailAgentSettings
+	config conf.SpoonConfigAgent
+	rules  []*logtailRule
+
+	lock    sync.Mutex
+	started bool
+	files   map[string]*tailedFile
+}
+
+type logtailAgentSettings struct {
+	// Files is a list of paths or glob patterns to follow
+	Files []string            `json:"files"`
+	Rules []logtailRuleConfig `json:"rules"`
+	// StateFile stores the read offsets so that lines are not missed or read
+	// again when Spoon restarts
+	StateFile string `json:"state_file"`
+}
+
+type logtailRuleConfig struct {
+	Name  string `json:"name"`
+	Regex string `json:"regex"`
+	// ValueGroup is the named capture group holding a numeric value. If it is
+	// not set, only the matching lines are counted.
+	ValueGroup string `json:"value_group"`
+}
+
+// logtailRule is a compiled rule. Named capture groups other than the value
+// group become tags.
+type logtailRule struct {
+	logtailRuleConfig
+	regex     *regexp.Regexp
+	tagGroups []string
+}
+
+// logtailAggregate is the result of a rule for a set of tags over one tick
+type logtailAggregate struct {
+	path     string
+	tags     sink.Tags
+	count    uint64
+	hasValue bool
+	sum      float64
+	max      float64
+}
+
+type tailedFile struct {
+	file   *os.File
+	inode  uint64
+	offset int64
+}
+
+type logtailState struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+func NewLogtailAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := logtailAgentSettings{}
+	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %s", err)
+	}
+	if len(s.Files) < 1 {
+		return nil, errors.New("logtailAgent 'files' setting must have at least one item")
+	}
+	for _, pattern := range s.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("logtailAgent file pattern '%s' is invalid: %s", pattern, err)
+		}
+	}
+	if len(s.Rules) < 1 {
+		return nil, errors.New("logtailAgent 'rules' setting must have at least one item")
+	}
+
+	validName := regexp.MustCompile("^" + constants.ValidPathPartRegex + "$")
+	rules := make([]*logtailRule, len(s.Rules))
+	for i, rc := range s.Rules {
+		if !validName.MatchString(rc.Name) {
+			return nil, fmt.Errorf("logtailAgent rule name '%s' is not a valid path segment", rc.Name)
+		}
+		r, err := regexp.Compile(rc.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("logtailAgent rule '%s' has invalid regex: %s", rc.Name, err)
+		}
+		rule := &logtailRule{logtailRuleConfig: rc, regex: r}
+		foundValue := rc.ValueGroup == ""
+		for _, g := range r.SubexpNames() {
+			if g == "" {
+				continue
+			}
+			if g == rc.ValueGroup {
+				foundValue = true
+			} else {
+				rule.tagGroups = append(rule.tagGroups, g)
+			}
+		}
+		if !foundValue {
+			return nil, fmt.Errorf("logtailAgent rule '%s' regex has no group named '%s'", rc.Name, rc.ValueGroup)
+		}
+		sort.Strings(rule.tagGroups)
+		rules[i] = rule
+	}
+
+	return &logtailAgent{
+		logtailAgentSettings: s,
+		config:               *config,
+		rules:                rules,
+		lock:                 sync.Mutex{},
+		files:                map[string]*tailedFile{},
+	}, nil
+}
+
+func (a *logtailAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *logtailAgent) Tick(ctx context.Context, s sink.Sink) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	// on the first tick, files without a saved offset are read from the end
+	// so that old lines are not reported. Files that appear later are read
+	// from the start.
+	firstTick := !a.started
+	var state map[string]logtailState
+	if firstTick {
+		a.started = true
+		state = a.loadState()
+		go func() {
+			<-ctx.Done()
+			a.closeAll()
+		}()
+	}
+
+	paths := map[string]bool{}
+	for _, pattern := range a.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid file pattern '%s': %s", pattern, err)
+		}
+		for _, m := range matches {
+			paths[m] = true
+		}
+	}
+
+	aggregates := map[string]*logtailAggregate{}
+	handle := func(line string) {
+		a.applyRules(line, aggregates)
+	}
+
+	// drain and forget files that no longer exist
+	for path, tf := range a.files {
+		if !paths[path] {
+			a.readLines(tf, handle)
+			tf.file.Close()
+			delete(a.files, path)
+		}
+	}
+
+	for path := range paths {
+		if err := a.tailFile(path, firstTick, state, handle); err != nil {
+			log.Printf("Failed to read %s: %s", path, err)
+		}
+	}
+
+	keys := make([]string, 0, len(aggregates))
+	for k := range aggregates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		agg := aggregates[k]
+		s.Count(agg.path+".count", agg.count, agg.tags)
+		if agg.hasValue {
+			s.Count(agg.path+".sum", agg.sum, agg.tags)
+			s.Gauge(agg.path+".max", agg.max, agg.tags)
+			s.Gauge(agg.path+".mean", agg.sum/float64(agg.count), agg.tags)
+		}
+	}
+
+	return a.saveState()
+}
+
+// tailFile reads any new lines from the file, handling rotation and
+// truncation. It must be called with the lock held.
+func (a *logtailAgent) tailFile(path string, firstTick bool, state map[string]logtailState, handle func(string)) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	inode := info.Sys().(*syscall.Stat_t).Ino
+
+	tf, ok := a.files[path]
+	if ok && tf.inode != inode {
+		// the file has been rotated, so finish reading the old one first
+		a.readLines(tf, handle)
+		tf.file.Close()
+		delete(a.files, path)
+		ok = false
+	}
+
+	if !ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		tf = &tailedFile{file: f, inode: inode}
+		if saved, found := state[path]; found && saved.Inode == inode && saved.Offset <= info.Size() {
+			tf.offset = saved.Offset
+		} else if firstTick && !found {
+			tf.offset = info.Size()
+		}
+		a.files[path] = tf
+	}
+
+	if info.Size() < tf.offset {
+		log.Printf("%s has been truncated, reading from the start", path)
+		tf.offset = 0
+	}
+	return a.readLines(tf, handle)
+}
+
+// readLines passes each complete line after the current offset to the handler
+// and advances the offset. Incomplete lines are left for the next read.
+func (a *logtailAgent) readLines(tf *tailedFile, handle func(string)) error {
+	if _, err := tf.file.Seek(tf.offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(tf.file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		tf.offset += int64(len(line))
+		handle(strings.TrimRight(line, "\r\n"))
+	}
+}
+
+func (a *logtailAgent) applyRules(line string, aggregates map[string]*logtailAggregate) {
+	for _, rule := range a.rules {
+		match := rule.regex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		path := a.config.Path + "." + rule.Name
+		var tags sink.Tags
+		var value float64
+		hasValue := false
+		for i, g := range rule.regex.SubexpNames() {
+			if g == "" {
+				continue
+			}
+			if g == rule.ValueGroup {
+				v, err := strconv.ParseFloat(match[i], 64)
+				if err != nil {
+					continue
+				}
+				value, hasValue = v, true
+			} else {
+				if tags == nil {
+					tags = sink.Tags{}
+				}
+				tags[g] = sanitizePathPart(match[i])
+			}
+		}
+		if rule.ValueGroup != "" && !hasValue {
+			continue
+		}
+		for _, g := range rule.tagGroups {
+			path += ".{" + g + "}"
+		}
+
+		key := sink.ExpandPath(path, tags)
+		agg, ok := aggregates[key]
+		if !ok {
+			agg = &logtailAggregate{path: path, tags: tags, hasValue: hasValue, max: value}
+			aggregates[key] = agg
+		}
+		agg.count++
+		if hasValue {
+			agg.sum += value
+			if value > agg.max {
+				agg.max = value
+			}
+		}
+	}
+}
+
+func (a *logtailAgent) closeAll() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for path, tf := range a.files {
+		tf.file.Close()
+		delete(a.files, path)
+	}
+}
+
+func (a *logtailAgent) loadState() map[string]logtailState {
+	state := map[string]logtailState{}
+	if a.StateFile == "" {
+		return state
+	}
+	data, err := ioutil.ReadFile(a.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read logtail state from %s: %s", a.StateFile, err)
+		}
+		return state
+	}
+	if err = json.Unmarshal(data, &state); err != nil {
+		log.Printf("Failed to parse logtail state from %s: %s", a.StateFile, err)
+	}
+	return state
+}
+
+// saveState writes the offsets to the state file. It is written to a
+// temporary file first so that a crash can't leave a partial state file.
+func (a *logtailAgent) saveState() error {
+	if a.StateFile == "" {
+		return nil
+	}
+	state := map[string]logtailState{}
+	for path, tf := range a.files {
+		state[path] = logtailState{Inode: tf.inode, Offset: tf.offset}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := a.StateFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write logtail state: %s", err)
+	}
+	if err = os.Rename(tmp, a.StateFile); err != nil {
+		return fmt.Errorf("failed to write logtail state: %s", err)
+	}
+	return nil
+}
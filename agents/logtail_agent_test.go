@@ -0,0 +1,194 @@
+package agents
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// newLogtailTestAgent builds a logtail agent following the .log files in dir.
+// Error lines are counted by their code, and request lines report their
+// duration.
+func newLogtailTestAgent(t *testing.T, dir string, stateFile string) *logtailAgent {
+	t.Helper()
+	agent, err := NewLogtailAgent(testAgentConfig(t, "logtail", "logs", map[string]interface{}{
+		"files": []string{filepath.Join(dir, "*.log")},
+		"rules": []map[string]string{
+			{"name": "errors", "regex": `ERROR code=(?P<code>[0-9]+)`},
+			{"name": "requests", "regex": `took=(?P<took>[0-9]+)`, "value_group": "took"},
+		},
+		"state_file": stateFile,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent.(*logtailAgent)
+}
+
+func logtailTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "logtail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func appendLog(t *testing.T, path string, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tickLogtail(t *testing.T, agent *logtailAgent) *testSink {
+	t.Helper()
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err != nil {
+		t.Fatalf("tick failed: %s", err)
+	}
+	return s
+}
+
+func TestLogtailAgentAppend(t *testing.T) {
+	dir := logtailTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLog(t, path, "ERROR code=500\n")
+
+	// lines written before the first tick are not reported
+	agent := newLogtailTestAgent(t, dir, "")
+	s := tickLogtail(t, agent)
+	s.expectMissing(t, "logs.errors.500.count")
+
+	appendLog(t, path, "ERROR code=500\nERROR code=404\ntook=10\ntook=30\nnothing\n")
+	s = tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 1)
+	s.expect(t, "logs.errors.404.count", 1)
+	s.expect(t, "logs.requests.count", 2)
+	s.expect(t, "logs.requests.sum", 40)
+	s.expect(t, "logs.requests.max", 30)
+	s.expect(t, "logs.requests.mean", 20)
+
+	// files that appear later match the glob and are read from the start
+	appendLog(t, filepath.Join(dir, "other.log"), "ERROR code=500\nERROR code=500\n")
+	appendLog(t, filepath.Join(dir, "other.txt"), "ERROR code=500\n")
+	s = tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 2)
+	s.expectMissing(t, "logs.errors.404.count")
+}
+
+func TestLogtailAgentRotation(t *testing.T) {
+	dir := logtailTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLog(t, path, "")
+
+	agent := newLogtailTestAgent(t, dir, "")
+	tickLogtail(t, agent)
+
+	// lines written just before the rotation are still read from the old file
+	appendLog(t, path, "ERROR code=500\n")
+	if err := os.Rename(path, filepath.Join(dir, "app.log.1")); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "ERROR code=500\nERROR code=500\n")
+
+	s := tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 3)
+
+	appendLog(t, path, "ERROR code=500\n")
+	s = tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 1)
+}
+
+func TestLogtailAgentTruncation(t *testing.T) {
+	dir := logtailTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLog(t, path, "")
+
+	agent := newLogtailTestAgent(t, dir, "")
+	tickLogtail(t, agent)
+	appendLog(t, path, "ERROR code=500\nERROR code=500\nERROR code=500\n")
+	s := tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 3)
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "ERROR code=404\n")
+	s = tickLogtail(t, agent)
+	s.expect(t, "logs.errors.404.count", 1)
+	s.expectMissing(t, "logs.errors.500.count")
+}
+
+func TestLogtailAgentPartialLine(t *testing.T) {
+	dir := logtailTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	appendLog(t, path, "")
+
+	agent := newLogtailTestAgent(t, dir, "")
+	tickLogtail(t, agent)
+
+	// the last line is only read once it is complete
+	appendLog(t, path, "ERROR code=404\nERROR code=5")
+	s := tickLogtail(t, agent)
+	s.expect(t, "logs.errors.404.count", 1)
+	s.expectMissing(t, "logs.errors.5.count")
+
+	appendLog(t, path, "00\n")
+	s = tickLogtail(t, agent)
+	s.expect(t, "logs.errors.500.count", 1)
+	s.expectMissing(t, "logs.errors.404.count")
+}
+
+func TestLogtailAgentStateFile(t *testing.T) {
+	dir := logtailTempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.log")
+	stateFile := filepath.Join(dir, "state.json")
+	appendLog(t, path, "ERROR code=500\n")
+
+	first := newLogtailTestAgent(t, dir, stateFile)
+	tickLogtail(t, first)
+
+	// a restarted agent carries on from the saved offset rather than the end
+	appendLog(t, path, "ERROR code=404\n")
+	second := newLogtailTestAgent(t, dir, stateFile)
+	s := tickLogtail(t, second)
+	s.expect(t, "logs.errors.404.count", 1)
+	s.expectMissing(t, "logs.errors.500.count")
+
+	// if the file was replaced while stopped, the new one is read from the
+	// start. It is written before the old one is removed so that it can't
+	// reuse the inode.
+	replacement := filepath.Join(dir, "app.new")
+	appendLog(t, replacement, "ERROR code=503\nERROR code=503\n")
+	if err := os.Rename(replacement, path); err != nil {
+		t.Fatal(err)
+	}
+	third := newLogtailTestAgent(t, dir, stateFile)
+	s = tickLogtail(t, third)
+	s.expect(t, "logs.errors.503.count", 2)
+	s.expectMissing(t, "logs.errors.404.count")
+}
+
+func TestLogtailAgentInvalidPattern(t *testing.T) {
+	_, err := NewLogtailAgent(testAgentConfig(t, "logtail", "logs", map[string]interface{}{
+		"files": []string{"/var/log/[app.log"},
+		"rules": []map[string]string{{"name": "errors", "regex": "ERROR"}},
+	}))
+	if err == nil {
+		t.Error("expected an error for an invalid file pattern")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
 	"github.com/shirou/gopsutil/mem"
+	"golang.org/x/net/context"
 )
 
 type memAgent struct {
@@ -22,7 +23,7 @@ func (a *memAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *memAgent) Tick(s sink.Sink) error {
+func (a *memAgent) Tick(ctx context.Context, s sink.Sink) error {
 
 	vmemInfo, err := mem.VirtualMemory()
 	if err != nil {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type metaAgent struct {
@@ -43,7 +44,7 @@ func (a *metaAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *metaAgent) Tick(s sink.Sink) error {
+func (a *metaAgent) Tick(ctx context.Context, s sink.Sink) error {
 
 	err1 := a.doCPU(s)
 	err2 := a.doMem(s)
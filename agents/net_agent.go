@@ -10,15 +10,18 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type netAgent struct {
 	netAgentSettings
-	config conf.SpoonConfigAgent
+	config   conf.SpoonConfigAgent
+	counters *counterTracker
 }
 
 type netAgentSettings struct {
 	NicRegex string `json:"nic_regex"`
+	Rates    bool   `json:"rates"`
 }
 
 func NewNetAgent(config *conf.SpoonConfigAgent) (Agent, error) {
@@ -29,6 +32,7 @@ func NewNetAgent(config *conf.SpoonConfigAgent) (Agent, error) {
 	return &netAgent{
 		netAgentSettings: s,
 		config:           (*config),
+		counters:         newCounterTracker(),
 	}, nil
 }
 
@@ -36,7 +40,7 @@ func (a *netAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *netAgent) Tick(s sink.Sink) error {
+func (a *netAgent) Tick(ctx context.Context, s sink.Sink) error {
 
 	iocounters, err := net.IOCounters(true)
 	if err != nil {
@@ -51,12 +55,13 @@ func (a *netAgent) Tick(s sink.Sink) error {
 			}
 		}
 		log.Printf("Outputting metrics for %v because it matched nic_regex", nicio.Name)
-		prefixPath := fmt.Sprintf("%s.%s", a.config.Path, nicio.Name)
+		prefixPath := fmt.Sprintf("%s.{interface}", a.config.Path)
+		tags := sink.Tags{"interface": nicio.Name}
 
-		s.Gauge(fmt.Sprintf("%s.tx_bytes", prefixPath), float64(nicio.BytesSent))
-		s.Gauge(fmt.Sprintf("%s.rx_bytes", prefixPath), float64(nicio.BytesRecv))
-		s.Gauge(fmt.Sprintf("%s.tx_packets", prefixPath), float64(nicio.PacketsSent))
-		s.Gauge(fmt.Sprintf("%s.rx_packets", prefixPath), float64(nicio.PacketsRecv))
+		a.counters.count(s, prefixPath+".tx_bytes_delta", a.ratePath(prefixPath, "tx_bytes_per_sec"), nicio.BytesSent, tags)
+		a.counters.count(s, prefixPath+".rx_bytes_delta", a.ratePath(prefixPath, "rx_bytes_per_sec"), nicio.BytesRecv, tags)
+		a.counters.count(s, prefixPath+".tx_packets_delta", a.ratePath(prefixPath, "tx_packets_per_sec"), nicio.PacketsSent, tags)
+		a.counters.count(s, prefixPath+".rx_packets_delta", a.ratePath(prefixPath, "rx_packets_per_sec"), nicio.PacketsRecv, tags)
 
 		// TODO do we need the error and dropped counts?
 	}
@@ -67,3 +72,12 @@ func (a *netAgent) Tick(s sink.Sink) error {
 
 	return nil
 }
+
+// ratePath returns the path for a rate metric, or an empty string if rates
+// are not enabled
+func (a *netAgent) ratePath(prefixPath, name string) string {
+	if !a.Rates {
+		return ""
+	}
+	return prefixPath + "." + name
+}
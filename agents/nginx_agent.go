@@ -0,0 +1,132 @@
+package agents
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type nginxAgent struct {
+	statusPageSettings
+	config   conf.SpoonConfigAgent
+	counters *counterTracker
+}
+
+// nginxStatus is the content of the nginx stub_status page
+type nginxStatus struct {
+	Active   uint64
+	Accepts  uint64
+	Handled  uint64
+	Requests uint64
+	Reading  uint64
+	Writing  uint64
+	Waiting  uint64
+}
+
+func NewNginxAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := statusPageSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.URL == "" {
+		s.URL = "http://127.0.0.1/nginx_status"
+	}
+	return &nginxAgent{
+		statusPageSettings: s,
+		config:             *config,
+		counters:           newCounterTracker(),
+	}, nil
+}
+
+func (a *nginxAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *nginxAgent) Tick(ctx context.Context, s sink.Sink) error {
+	data, err := fetchStatusPage(ctx, a.config, a.statusPageSettings)
+	if err != nil {
+		return fmt.Errorf("failed to fetch nginx status: %s", err)
+	}
+	status, err := parseNginxStatus(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse nginx status: %s", err)
+	}
+
+	s.Gauge(a.config.Path+".active_connections", status.Active)
+	s.Gauge(a.config.Path+".reading", status.Reading)
+	s.Gauge(a.config.Path+".writing", status.Writing)
+	s.Gauge(a.config.Path+".waiting", status.Waiting)
+	a.counters.count(s, a.config.Path+".accepts", a.config.Path+".accepts_per_sec", status.Accepts, nil)
+	a.counters.count(s, a.config.Path+".handled", a.config.Path+".handled_per_sec", status.Handled, nil)
+	a.counters.count(s, a.config.Path+".requests", a.config.Path+".requests_per_sec", status.Requests, nil)
+	return nil
+}
+
+// parseNginxStatus parses the stub_status page, which looks like:
+//
+//	Active connections: 291
+//	server accepts handled requests
+//	 16630948 16630948 31070465
+//	Reading: 6 Writing: 179 Waiting: 106
+func parseNginxStatus(r io.Reader) (*nginxStatus, error) {
+	status := &nginxStatus{}
+	scanner := bufio.NewScanner(r)
+	found := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "Active connections:") && len(fields) == 3:
+			v, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid active connections '%s'", fields[2])
+			}
+			status.Active = v
+			found++
+		case strings.HasPrefix(line, "server accepts handled requests"):
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("missing accepts, handled, and requests")
+			}
+			values := strings.Fields(scanner.Text())
+			if len(values) < 3 {
+				return nil, fmt.Errorf("missing accepts, handled, and requests")
+			}
+			targets := []*uint64{&status.Accepts, &status.Handled, &status.Requests}
+			for i, t := range targets {
+				v, err := strconv.ParseUint(values[i], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid counter '%s'", values[i])
+				}
+				*t = v
+			}
+			found++
+		case strings.HasPrefix(line, "Reading:") && len(fields) == 6:
+			targets := []*uint64{&status.Reading, &status.Writing, &status.Waiting}
+			for i, t := range targets {
+				v, err := strconv.ParseUint(fields[i*2+1], 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid connection count '%s'", fields[i*2+1])
+				}
+				*t = v
+			}
+			found++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if found != 3 {
+		return nil, fmt.Errorf("page does not look like nginx stub_status")
+	}
+	return status, nil
+}
@@ -0,0 +1,85 @@
+package agents
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseNginxStatus(t *testing.T) {
+	f, err := os.Open("testdata/status/nginx_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	status, err := parseNginxStatus(f)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	expected := nginxStatus{
+		Active:   291,
+		Accepts:  16630948,
+		Handled:  16630948,
+		Requests: 31070465,
+		Reading:  6,
+		Writing:  179,
+		Waiting:  106,
+	}
+	if *status != expected {
+		t.Errorf("expected %+v, got %+v", expected, *status)
+	}
+}
+
+func TestParseNginxStatusMalformed(t *testing.T) {
+	f, err := os.Open("testdata/status/malformed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := parseNginxStatus(f); err == nil {
+		t.Error("expected an error for a page that is not stub_status")
+	}
+}
+
+func TestNginxAgent(t *testing.T) {
+	server := statusPageServer(t, "testdata/status/nginx_1", "testdata/status/nginx_2")
+	defer server.Close()
+
+	agent, err := NewNginxAgent(testAgentConfig(t, "nginx", "nginx", map[string]interface{}{"url": server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "nginx.active_connections", 300)
+	s.expect(t, "nginx.reading", 2)
+	s.expect(t, "nginx.writing", 180)
+	s.expect(t, "nginx.waiting", 118)
+	s.expect(t, "nginx.accepts", 100)
+	s.expect(t, "nginx.handled", 100)
+	s.expect(t, "nginx.requests", 1000)
+	s.get(t, "nginx.requests_per_sec")
+}
+
+func TestNginxAgentMalformedPage(t *testing.T) {
+	server := statusPageServer(t, "testdata/status/malformed")
+	defer server.Close()
+
+	agent, err := NewNginxAgent(testAgentConfig(t, "nginx", "nginx", map[string]interface{}{"url": server.URL}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err == nil {
+		t.Error("expected an error for a malformed page")
+	}
+	s.expectMissing(t, "nginx.active_connections")
+}
@@ -0,0 +1,11 @@
+package agents
+
+import "regexp"
+
+var invalidPathPartChars = regexp.MustCompile("[^a-zA-Z0-9\\-\\_]+")
+
+// sanitizePathPart replaces any characters that are not allowed in a metric
+// path segment with underscores.
+func sanitizePathPart(part string) string {
+	return invalidPathPartChars.ReplaceAllString(part, "_")
+}
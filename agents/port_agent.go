@@ -0,0 +1,183 @@
+package agents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/constants"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// portAgentRefusedWait is how long to wait for a udp port to be refused
+const portAgentRefusedWait = 500 * time.Millisecond
+
+type portAgent struct {
+	portAgentSettings
+	config conf.SpoonConfigAgent
+}
+
+type portAgentSettings struct {
+	Targets []portTargetSettings `json:"targets"`
+}
+
+type portTargetSettings struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Protocol is either tcp (the default) or udp
+	Protocol string `json:"protocol"`
+	// Send is written to the connection once it is open
+	Send string `json:"send"`
+	// Expect must be contained in the response for the check to succeed
+	Expect string `json:"expect"`
+	// Timeout is in seconds and is capped at the interval of the agent
+	Timeout float64 `json:"timeout"`
+}
+
+func NewPortAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := portAgentSettings{}
+	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %s", err)
+	}
+	if len(s.Targets) < 1 {
+		return nil, errors.New("portAgent 'targets' setting must have at least one item")
+	}
+
+	validName := regexp.MustCompile("^" + constants.ValidPathPartRegex + "$")
+	for i, t := range s.Targets {
+		if !validName.MatchString(t.Name) {
+			return nil, fmt.Errorf("portAgent target name '%s' is not a valid path segment", t.Name)
+		}
+		if _, _, err := net.SplitHostPort(t.Address); err != nil {
+			return nil, fmt.Errorf("portAgent target '%s' has invalid address: %s", t.Name, err)
+		}
+		switch strings.ToLower(t.Protocol) {
+		case "":
+			s.Targets[i].Protocol = "tcp"
+		case "tcp", "udp":
+			s.Targets[i].Protocol = strings.ToLower(t.Protocol)
+		default:
+			return nil, fmt.Errorf("portAgent target '%s' protocol must be either tcp or udp", t.Name)
+		}
+		if t.Timeout < 0 {
+			return nil, fmt.Errorf("portAgent target '%s' timeout must be positive", t.Name)
+		}
+	}
+
+	return &portAgent{
+		portAgentSettings: s,
+		config:            *config,
+	}, nil
+}
+
+func (a *portAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *portAgent) Tick(ctx context.Context, s sink.Sink) error {
+	wg := sync.WaitGroup{}
+	for _, t := range a.Targets {
+		wg.Add(1)
+		go func(t portTargetSettings) {
+			defer wg.Done()
+			a.doTarget(ctx, s, t)
+		}(t)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (a *portAgent) doTarget(ctx context.Context, s sink.Sink, t portTargetSettings) {
+	prefixPath := a.config.Path + ".{target}"
+	tags := sink.Tags{"target": t.Name}
+
+	timeout := time.Duration(float64(a.config.Interval) * float64(time.Second))
+	if t.Timeout > 0 && time.Duration(t.Timeout*float64(time.Second)) < timeout {
+		timeout = time.Duration(t.Timeout * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := a.check(ctx, s, t, prefixPath, tags)
+	if err != nil {
+		log.Printf("Port check for %s %s failed: %s", t.Protocol, t.Address, err)
+	}
+	success := 0
+	if err == nil {
+		success = 1
+	}
+	s.Gauge(prefixPath+".success", success, tags)
+}
+
+// check connects to the target, and then sends and expects the payloads if
+// they are configured. For udp, connecting doesn't involve the server, so a
+// datagram is always sent and a closed port is only noticed if the server
+// responds with an icmp error.
+func (a *portAgent) check(ctx context.Context, s sink.Sink, t portTargetSettings, prefixPath string, tags sink.Tags) error {
+	dialer := net.Dialer{}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, t.Protocol, t.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if t.Protocol == "tcp" {
+		s.Timing(prefixPath+".connect_seconds", time.Since(start).Seconds(), tags)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// udp always sends something, even an empty datagram, so that a closed
+	// port has a chance to refuse it
+	if t.Send != "" || t.Protocol == "udp" {
+		if _, err = conn.Write([]byte(t.Send)); err != nil {
+			return err
+		}
+	}
+	if t.Expect == "" && t.Protocol == "tcp" {
+		return nil
+	}
+
+	// only wait briefly for an icmp error when no response is expected
+	if t.Expect == "" {
+		conn.SetReadDeadline(time.Now().Add(portAgentRefusedWait))
+	}
+
+	start = time.Now()
+	buf := make([]byte, 65535)
+	received := ""
+	for {
+		n, err := conn.Read(buf)
+		received += string(buf[:n])
+		if t.Expect != "" && strings.Contains(received, t.Expect) {
+			s.Timing(prefixPath+".response_seconds", time.Since(start).Seconds(), tags)
+			return nil
+		}
+		if err != nil {
+			// without an expected response, a udp port which didn't refuse
+			// the payload is assumed to be open
+			if t.Expect == "" {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					return nil
+				}
+			}
+			return err
+		}
+		if t.Protocol == "udp" {
+			// any reply shows the port is open if nothing is expected
+			if t.Expect == "" {
+				return nil
+			}
+			return fmt.Errorf("response did not contain %q", t.Expect)
+		}
+	}
+}
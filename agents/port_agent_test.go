@@ -0,0 +1,91 @@
+package agents
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// closedAddress returns a local address that nothing is listening on
+func closedAddress(t *testing.T, network string) string {
+	t.Helper()
+	var addr string
+	if network == "udp" {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = conn.LocalAddr().String()
+		conn.Close()
+	} else {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = l.Addr().String()
+		l.Close()
+	}
+	return addr
+}
+
+func TestPortAgent(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	// the udp server echoes each datagram back
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			udp.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	agent, err := NewPortAgent(testAgentConfig(t, "port", "ports", map[string]interface{}{
+		"targets": []map[string]interface{}{
+			{"name": "tcp_open", "address": tcp.Addr().String()},
+			{"name": "tcp_closed", "address": closedAddress(t, "tcp")},
+			{"name": "udp_open", "protocol": "udp", "address": udp.LocalAddr().String()},
+			{"name": "udp_closed", "protocol": "udp", "address": closedAddress(t, "udp")},
+			{"name": "udp_echo", "protocol": "udp", "address": udp.LocalAddr().String(), "send": "ping", "expect": "ping"},
+			{"name": "udp_wrong", "protocol": "udp", "address": udp.LocalAddr().String(), "send": "ping", "expect": "pong"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, "ports.tcp_open.success", 1)
+	s.get(t, "ports.tcp_open.connect_seconds")
+	s.expect(t, "ports.tcp_closed.success", 0)
+	s.expect(t, "ports.udp_open.success", 1)
+	s.expect(t, "ports.udp_closed.success", 0)
+	s.expect(t, "ports.udp_echo.success", 1)
+	s.get(t, "ports.udp_echo.response_seconds")
+	s.expect(t, "ports.udp_wrong.success", 0)
+}
@@ -0,0 +1,174 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+var pressureResources = []string{"cpu", "memory", "io"}
+
+type pressureAgent struct {
+	pressureAgentSettings
+	config   conf.SpoonConfigAgent
+	counters *counterTracker
+}
+
+type pressureAgentSettings struct {
+	// PressurePath is the directory holding the system wide PSI files
+	PressurePath string `json:"pressure_path"`
+	// CgroupRoot is where the cgroup v2 hierarchy is mounted
+	CgroupRoot string `json:"cgroup_root"`
+	// Cgroups is a list of cgroup v2 directories to report PSI for, either
+	// absolute or relative to the CgroupRoot
+	Cgroups []string `json:"cgroups"`
+}
+
+// pressureStats is a single line from a PSI file. The averages are
+// percentages of wall time and the total is the stall time in microseconds.
+type pressureStats struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  uint64
+}
+
+func NewPressureAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := pressureAgentSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.PressurePath == "" {
+		s.PressurePath = "/proc/pressure"
+	}
+	if s.CgroupRoot == "" {
+		s.CgroupRoot = "/sys/fs/cgroup"
+	}
+	return &pressureAgent{
+		pressureAgentSettings: s,
+		config:                (*config),
+		counters:              newCounterTracker(),
+	}, nil
+}
+
+func (a *pressureAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *pressureAgent) Tick(ctx context.Context, s sink.Sink) error {
+	// carry on with the cgroups if the system wide files are missing, or with
+	// the remaining cgroups if one of them has gone away
+	var lastErr error
+	if err := a.doDirectory(s, a.PressurePath, "", a.config.Path, nil); err != nil {
+		lastErr = fmt.Errorf("failed to read pressure from %s: %s", a.PressurePath, err)
+	}
+	for _, cgroup := range a.Cgroups {
+		directory, name := a.resolveCgroup(cgroup)
+		tags := sink.Tags{"cgroup": name}
+		if err := a.doDirectory(s, directory, ".pressure", a.config.Path+".cgroup.{cgroup}", tags); err != nil {
+			lastErr = fmt.Errorf("failed to read pressure for cgroup %s: %s", cgroup, err)
+		}
+	}
+	return lastErr
+}
+
+// resolveCgroup returns the directory of the cgroup and the name used for it
+// in the metric path. The name comes from the path relative to the cgroup root
+// so that cgroups with the same base name in different slices don't collide.
+func (a *pressureAgent) resolveCgroup(cgroup string) (string, string) {
+	directory := cgroup
+	if !filepath.IsAbs(directory) {
+		directory = filepath.Join(a.CgroupRoot, directory)
+	}
+	name, err := filepath.Rel(a.CgroupRoot, directory)
+	if err != nil || strings.HasPrefix(name, "..") {
+		name = directory
+	}
+	return directory, strings.Trim(sanitizePathPart(name), "_")
+}
+
+// doDirectory reports each of the PSI files in the directory. The system wide
+// files are named after the resource, while the cgroup files have a suffix.
+func (a *pressureAgent) doDirectory(s sink.Sink, directory string, suffix string, prefixPath string, tags sink.Tags) error {
+	for _, resource := range pressureResources {
+		stats, err := readPressureFile(filepath.Join(directory, resource+suffix))
+		if err != nil {
+			return err
+		}
+		for _, kind := range []string{"some", "full"} {
+			st, ok := stats[kind]
+			if !ok {
+				continue
+			}
+			path := prefixPath + "." + resource + "." + kind
+			s.Gauge(path+".avg10", st.Avg10, tags)
+			s.Gauge(path+".avg60", st.Avg60, tags)
+			s.Gauge(path+".avg300", st.Avg300, tags)
+			a.counters.count(s, path+".total_us", "", st.Total, tags)
+		}
+	}
+	return nil
+}
+
+func readPressureFile(path string) (map[string]pressureStats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePressure(f)
+}
+
+// parsePressure parses the content of a PSI file, which has a line for each
+// of the "some" and "full" kinds like:
+//
+//	some avg10=0.00 avg60=0.12 avg300=0.05 total=123456
+//
+// The result is keyed by the kind.
+func parsePressure(r io.Reader) (map[string]pressureStats, error) {
+	output := map[string]pressureStats{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		st := pressureStats{}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("malformed field '%s'", field)
+			}
+			var err error
+			switch parts[0] {
+			case "avg10":
+				st.Avg10, err = strconv.ParseFloat(parts[1], 64)
+			case "avg60":
+				st.Avg60, err = strconv.ParseFloat(parts[1], 64)
+			case "avg300":
+				st.Avg300, err = strconv.ParseFloat(parts[1], 64)
+			case "total":
+				st.Total, err = strconv.ParseUint(parts[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("malformed field '%s': %s", field, err)
+			}
+		}
+		output[fields[0]] = st
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,97 @@
+package agents
+
+import (
+	"os"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestParsePressure(t *testing.T) {
+	f, err := os.Open("testdata/pressure/proc/memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stats, err := parsePressure(f)
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	expected := map[string]pressureStats{
+		"some": {Avg10: 0.10, Avg60: 0.20, Avg300: 0.30, Total: 1000},
+		"full": {Avg10: 0.05, Avg60: 0.06, Avg300: 0.07, Total: 500},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d lines, got %d", len(expected), len(stats))
+	}
+	for kind, e := range expected {
+		if stats[kind] != e {
+			t.Errorf("expected %s to be %+v, got %+v", kind, e, stats[kind])
+		}
+	}
+}
+
+func TestParsePressureMalformed(t *testing.T) {
+	f, err := os.Open("testdata/pressure/malformed_value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := parsePressure(f); err == nil {
+		t.Error("expected an error for a malformed value")
+	}
+}
+
+func TestPressureAgentSystemAndCgroups(t *testing.T) {
+	agent, err := NewPressureAgent(testAgentConfig(t, "pressure", "psi", map[string]interface{}{
+		"pressure_path": "testdata/pressure/proc",
+		"cgroup_root":   "testdata/pressure/cgroup",
+		"cgroups":       []string{"system.slice/app.service", "user.slice/app.service"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	// the system wide cpu file only has a some line on older kernels
+	s.expect(t, "psi.cpu.some.avg10", 1.5)
+	s.expect(t, "psi.cpu.some.avg300", 3)
+	s.expectMissing(t, "psi.cpu.full.avg10")
+	s.expect(t, "psi.io.some.avg60", 5)
+	s.expect(t, "psi.io.full.avg60", 8)
+	s.expect(t, "psi.io.full.total_us", 0)
+
+	// cgroups with the same base name are kept apart by their relative path
+	s.expect(t, "psi.cgroup.system_slice_app_service.memory.some.avg10", 11)
+	s.expect(t, "psi.cgroup.system_slice_app_service.memory.full.avg300", 23)
+	s.expect(t, "psi.cgroup.user_slice_app_service.memory.some.avg10", 31)
+	s.expect(t, "psi.cgroup.user_slice_app_service.memory.full.avg300", 43)
+	if tag := s.get(t, "psi.cgroup.user_slice_app_service.memory.full.avg10").tags["cgroup"]; tag != "user_slice_app_service" {
+		t.Errorf("expected cgroup tag user_slice_app_service, got %s", tag)
+	}
+}
+
+func TestPressureAgentMissingSystemFiles(t *testing.T) {
+	agent, err := NewPressureAgent(testAgentConfig(t, "pressure", "psi", map[string]interface{}{
+		"pressure_path": "testdata/pressure/missing",
+		"cgroup_root":   "testdata/pressure/cgroup",
+		"cgroups":       []string{"system.slice/app.service"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSink()
+	if err := agent.Tick(context.Background(), s); err == nil {
+		t.Error("expected an error for the missing system files")
+	}
+	s.expect(t, "psi.cgroup.system_slice_app_service.memory.some.avg10", 11)
+}
@@ -0,0 +1,224 @@
+package agents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/constants"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type processAgent struct {
+	processAgentSettings
+	config conf.SpoonConfigAgent
+	groups []*processGroup
+}
+
+type processAgentSettings struct {
+	Groups []processGroupSettings `json:"groups"`
+}
+
+type processGroupSettings struct {
+	Name         string `json:"name"`
+	NameRegex    string `json:"name_regex"`
+	CmdlineRegex string `json:"cmdline_regex"`
+	User         string `json:"user"`
+	PidFile      string `json:"pidfile"`
+}
+
+// processGroup holds the compiled selectors for a group along with the
+// previous sample of each matching process so that cpu and io can be
+// reported as changes since the last tick.
+type processGroup struct {
+	processGroupSettings
+	nameRegex    *regexp.Regexp
+	cmdlineRegex *regexp.Regexp
+	prevTime     time.Time
+	prevSamples  map[int32]processSample
+}
+
+type processSample struct {
+	cpuSeconds float64
+	readBytes  uint64
+	writeBytes uint64
+}
+
+func NewProcessAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := processAgentSettings{}
+	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %s", err)
+	}
+	if len(s.Groups) < 1 {
+		return nil, errors.New("processAgent 'groups' setting must have at least one item")
+	}
+
+	validName := regexp.MustCompile("^" + constants.ValidPathPartRegex + "$")
+	groups := make([]*processGroup, len(s.Groups))
+	for i, gs := range s.Groups {
+		if !validName.MatchString(gs.Name) {
+			return nil, fmt.Errorf("processAgent group name '%s' is not a valid path segment", gs.Name)
+		}
+		if gs.NameRegex == "" && gs.CmdlineRegex == "" && gs.User == "" && gs.PidFile == "" {
+			return nil, fmt.Errorf("processAgent group '%s' must have at least one of name_regex, cmdline_regex, user, or pidfile", gs.Name)
+		}
+		g := &processGroup{processGroupSettings: gs, prevSamples: map[int32]processSample{}}
+		var err error
+		if gs.NameRegex != "" {
+			if g.nameRegex, err = regexp.Compile(gs.NameRegex); err != nil {
+				return nil, fmt.Errorf("processAgent group '%s' has invalid name_regex: %s", gs.Name, err)
+			}
+		}
+		if gs.CmdlineRegex != "" {
+			if g.cmdlineRegex, err = regexp.Compile(gs.CmdlineRegex); err != nil {
+				return nil, fmt.Errorf("processAgent group '%s' has invalid cmdline_regex: %s", gs.Name, err)
+			}
+		}
+		groups[i] = g
+	}
+
+	return &processAgent{
+		processAgentSettings: s,
+		config:               (*config),
+		groups:               groups,
+	}, nil
+}
+
+func (a *processAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *processAgent) Tick(ctx context.Context, s sink.Sink) error {
+	pids, err := process.Pids()
+	if err != nil {
+		return fmt.Errorf("failed to list processes: %s", err)
+	}
+
+	for _, g := range a.groups {
+		a.doGroup(s, g, pids)
+	}
+	return nil
+}
+
+func (a *processAgent) doGroup(s sink.Sink, g *processGroup, pids []int32) {
+	now := time.Now()
+	prefixPath := a.config.Path + ".{group}"
+	tags := sink.Tags{"group": g.Name}
+
+	candidates := pids
+	if g.PidFile != "" {
+		candidates = nil
+		if pid, err := readPidFile(g.PidFile); err == nil {
+			candidates = []int32{pid}
+		}
+	}
+
+	instances := 0
+	var rss uint64
+	var fds, threads int32
+	var cpuDelta float64
+	var readDelta, writeDelta uint64
+	samples := map[int32]processSample{}
+	for _, pid := range candidates {
+		p, err := process.NewProcess(pid)
+		if err != nil || !g.matches(p) {
+			continue
+		}
+		instances++
+
+		// any of these can fail if the process exits or we lack permission,
+		// in which case we just skip that value
+		if mem, err := p.MemoryInfo(); err == nil {
+			rss += mem.RSS
+		}
+		if n, err := p.NumFDs(); err == nil {
+			fds += n
+		}
+		if n, err := p.NumThreads(); err == nil {
+			threads += n
+		}
+
+		sample := processSample{}
+		if times, err := p.Times(); err == nil {
+			sample.cpuSeconds = times.Total()
+		}
+		if io, err := p.IOCounters(); err == nil {
+			sample.readBytes = io.ReadBytes
+			sample.writeBytes = io.WriteBytes
+		}
+		samples[pid] = sample
+
+		// only processes seen on the previous tick contribute to the changes
+		if prev, ok := g.prevSamples[pid]; ok {
+			if sample.cpuSeconds >= prev.cpuSeconds {
+				cpuDelta += sample.cpuSeconds - prev.cpuSeconds
+			}
+			if sample.readBytes >= prev.readBytes {
+				readDelta += sample.readBytes - prev.readBytes
+			}
+			if sample.writeBytes >= prev.writeBytes {
+				writeDelta += sample.writeBytes - prev.writeBytes
+			}
+		}
+	}
+
+	s.Gauge(prefixPath+".instances", instances, tags)
+	s.Gauge(prefixPath+".rss_bytes", rss, tags)
+	s.Gauge(prefixPath+".open_fds", fds, tags)
+	s.Gauge(prefixPath+".threads", threads, tags)
+	if !g.prevTime.IsZero() {
+		elapsed := now.Sub(g.prevTime).Seconds()
+		if elapsed > 0 {
+			s.Gauge(prefixPath+".cpu_percent", cpuDelta/elapsed*100, tags)
+		}
+		s.Count(prefixPath+".read_bytes", readDelta, tags)
+		s.Count(prefixPath+".write_bytes", writeDelta, tags)
+	}
+
+	g.prevTime = now
+	g.prevSamples = samples
+}
+
+// matches returns true if the process matches all of the configured selectors
+func (g *processGroup) matches(p *process.Process) bool {
+	if g.nameRegex != nil {
+		name, err := p.Name()
+		if err != nil || !g.nameRegex.MatchString(name) {
+			return false
+		}
+	}
+	if g.cmdlineRegex != nil {
+		cmdline, err := p.Cmdline()
+		if err != nil || !g.cmdlineRegex.MatchString(cmdline) {
+			return false
+		}
+	}
+	if g.User != "" {
+		user, err := p.Username()
+		if err != nil || user != g.User {
+			return false
+		}
+	}
+	return true
+}
+
+func readPidFile(path string) (int32, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return int32(pid), nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type randomAgent struct {
@@ -45,7 +46,7 @@ func (a *randomAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *randomAgent) Tick(s sink.Sink) error {
+func (a *randomAgent) Tick(ctx context.Context, s sink.Sink) error {
 	rng := a.Max - a.Min
 	v := a.random.Float64()*rng + a.Min
 	s.Gauge(a.config.Path, v)
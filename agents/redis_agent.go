@@ -0,0 +1,255 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+type redisAgent struct {
+	redisAgentSettings
+	config   conf.SpoonConfigAgent
+	counters *counterTracker
+}
+
+type redisAgentSettings struct {
+	// Address is a host:port, or the path of a unix socket
+	Address  string `json:"address"`
+	Password string `json:"password"`
+	// CommandStats also reports the calls and time spent for each command
+	CommandStats bool `json:"commandstats"`
+	// Timeout is in seconds and is capped at the interval of the agent
+	Timeout float64 `json:"timeout"`
+}
+
+// redisGauges are the INFO fields reported as they are
+var redisGauges = []struct{ field, name string }{
+	{"used_memory", "used_memory_bytes"},
+	{"used_memory_rss", "used_memory_rss_bytes"},
+	{"used_memory_peak", "used_memory_peak_bytes"},
+	{"mem_fragmentation_ratio", "mem_fragmentation_ratio"},
+	{"connected_clients", "connected_clients"},
+	{"blocked_clients", "blocked_clients"},
+	{"instantaneous_ops_per_sec", "ops_per_sec"},
+	{"connected_slaves", "connected_replicas"},
+	{"master_repl_offset", "repl_offset"},
+	{"uptime_in_seconds", "uptime_seconds"},
+}
+
+// redisCounters are the INFO fields which only increase, and are reported as
+// the increase since the previous tick
+var redisCounters = []struct{ field, name string }{
+	{"total_commands_processed", "commands_processed"},
+	{"total_connections_received", "connections_received"},
+	{"rejected_connections", "rejected_connections"},
+	{"keyspace_hits", "keyspace_hits"},
+	{"keyspace_misses", "keyspace_misses"},
+	{"expired_keys", "expired_keys"},
+	{"evicted_keys", "evicted_keys"},
+}
+
+func NewRedisAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := redisAgentSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.Address == "" {
+		s.Address = "127.0.0.1:6379"
+	}
+	if s.Timeout < 0 {
+		return nil, errors.New("redisAgent 'timeout' setting must be positive")
+	}
+	return &redisAgent{
+		redisAgentSettings: s,
+		config:             *config,
+		counters:           newCounterTracker(),
+	}, nil
+}
+
+func (a *redisAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *redisAgent) Tick(ctx context.Context, s sink.Sink) error {
+	timeout := time.Duration(float64(a.config.Interval) * float64(time.Second))
+	if a.Timeout > 0 && time.Duration(a.Timeout*float64(time.Second)) < timeout {
+		timeout = time.Duration(a.Timeout * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	network := "tcp"
+	if strings.HasPrefix(a.Address, "/") {
+		network = "unix"
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, a.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to redis: %s", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	if a.Password != "" {
+		if _, err = redisCommand(rw, "AUTH", a.Password); err != nil {
+			return fmt.Errorf("failed to authenticate with redis: %s", err)
+		}
+	}
+
+	info, err := redisCommand(rw, "INFO")
+	if err != nil {
+		return fmt.Errorf("failed to run redis INFO: %s", err)
+	}
+	a.reportInfo(s, parseRedisInfo(info))
+
+	if a.CommandStats {
+		info, err = redisCommand(rw, "INFO", "commandstats")
+		if err != nil {
+			return fmt.Errorf("failed to run redis INFO commandstats: %s", err)
+		}
+		a.reportCommandStats(s, parseRedisInfo(info))
+	}
+	return nil
+}
+
+func (a *redisAgent) reportInfo(s sink.Sink, info map[string]string) {
+	for _, g := range redisGauges {
+		if v, err := strconv.ParseFloat(info[g.field], 64); err == nil {
+			s.Gauge(a.config.Path+"."+g.name, v)
+		}
+	}
+	for _, c := range redisCounters {
+		if v, err := strconv.ParseUint(info[c.field], 10, 64); err == nil {
+			a.counters.count(s, a.config.Path+"."+c.name, "", v, nil)
+		}
+	}
+	if role, ok := info["role"]; ok {
+		isMaster := 0
+		if role == "master" {
+			isMaster = 1
+		}
+		s.Gauge(a.config.Path+".is_master", isMaster)
+	}
+
+	// the keyspace section has a line per database like
+	// db0:keys=1,expires=0,avg_ttl=0
+	for key, value := range info {
+		if !strings.HasPrefix(key, "db") {
+			continue
+		}
+		if _, err := strconv.Atoi(key[2:]); err != nil {
+			continue
+		}
+		fields := parseRedisFields(value)
+		tags := sink.Tags{"db": key}
+		s.Gauge(a.config.Path+".keyspace.{db}.keys", fields["keys"], tags)
+		s.Gauge(a.config.Path+".keyspace.{db}.expires", fields["expires"], tags)
+	}
+}
+
+// reportCommandStats reports the lines of the commandstats section, like
+// cmdstat_get:calls=21,usec=175,usec_per_call=8.33
+func (a *redisAgent) reportCommandStats(s sink.Sink, info map[string]string) {
+	for key, value := range info {
+		if !strings.HasPrefix(key, "cmdstat_") {
+			continue
+		}
+		fields := parseRedisFields(value)
+		path := a.config.Path + ".commands.{command}"
+		tags := sink.Tags{"command": sanitizePathPart(strings.TrimPrefix(key, "cmdstat_"))}
+		a.counters.count(s, path+".calls", "", uint64(fields["calls"]), tags)
+		a.counters.count(s, path+".usec", "", uint64(fields["usec"]), tags)
+	}
+}
+
+// parseRedisInfo parses the output of INFO into its key:value pairs. Section
+// headers and blank lines are skipped.
+func parseRedisInfo(info string) map[string]string {
+	output := map[string]string{}
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 {
+			output[parts[0]] = parts[1]
+		}
+	}
+	return output
+}
+
+// parseRedisFields parses the comma separated key=value pairs used by the
+// keyspace and commandstats sections. Values which are not numbers are
+// skipped.
+func parseRedisFields(value string) map[string]float64 {
+	output := map[string]float64{}
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(parts[1], 64); err == nil {
+			output[parts[0]] = v
+		}
+	}
+	return output
+}
+
+// redisCommand sends a command using the RESP protocol and returns the reply.
+// Only the simple string, error, integer, and bulk string replies are
+// supported, which is all that AUTH and INFO return.
+func redisCommand(rw *bufio.ReadWriter, args ...string) (string, error) {
+	fmt.Fprintf(rw, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rw, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := rw.Flush(); err != nil {
+		return "", err
+	}
+
+	line, err := rw.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return "", errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid bulk string length '%s'", line[1:])
+		}
+		if n < 0 {
+			return "", nil
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(rw, data); err != nil {
+			return "", err
+		}
+		return string(data[:n]), nil
+	default:
+		return "", fmt.Errorf("unsupported reply type '%c'", line[0])
+	}
+}
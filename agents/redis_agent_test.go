@@ -0,0 +1,243 @@
+package agents
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fakeRedis answers AUTH and INFO commands over RESP. The counters in the INFO
+// fixtures go up by 10 on every call so that there is something to report.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+	// truncate cuts the INFO reply short and closes the connection
+	truncate bool
+
+	lock  sync.Mutex
+	calls int
+}
+
+func startFakeRedis(t *testing.T, password string, truncate bool) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener, password: password, truncate: truncate}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(t, conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := r.password == ""
+	for {
+		args, err := readRESPArray(reader)
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.ToUpper(args[0]) == "AUTH":
+			if len(args) == 2 && args[1] == r.password {
+				authenticated = true
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "-ERR invalid password\r\n")
+			}
+		case !authenticated:
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+		case strings.ToUpper(args[0]) == "INFO":
+			file := "testdata/redis/info"
+			if len(args) == 2 && args[1] == "commandstats" {
+				file = "testdata/redis/commandstats"
+			}
+			template, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			r.lock.Lock()
+			r.calls++
+			n := r.calls * 10
+			r.lock.Unlock()
+			body := strings.Replace(string(template), "%d", strconv.Itoa(n), -1)
+			body = strings.Replace(body, "\n", "\r\n", -1)
+			if r.truncate {
+				fmt.Fprintf(conn, "$%d\r\n%s", len(body), body[:len(body)/2])
+				return
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(body), body)
+		default:
+			io.WriteString(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+// readRESPArray reads a command sent by the client as an array of bulk strings
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid array header %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string header %q", line)
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:length])
+	}
+	return args, nil
+}
+
+func newTestRedisAgent(t *testing.T, settings map[string]interface{}) Agent {
+	t.Helper()
+	agent, err := NewRedisAgent(testAgentConfig(t, "redis", "redis", settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestRedisAgent(t *testing.T) {
+	r := startFakeRedis(t, "secret", false)
+	defer r.listener.Close()
+
+	agent := newTestRedisAgent(t, map[string]interface{}{
+		"address":      r.listener.Addr().String(),
+		"password":     "secret",
+		"commandstats": true,
+	})
+	s := newTestSink()
+	for i := 0; i < 2; i++ {
+		if err := agent.Tick(context.Background(), s); err != nil {
+			t.Fatalf("tick failed: %s", err)
+		}
+	}
+
+	s.expect(t, "redis.used_memory_bytes", 1048576)
+	s.expect(t, "redis.mem_fragmentation_ratio", 2)
+	s.expect(t, "redis.connected_clients", 10)
+	s.expect(t, "redis.ops_per_sec", 42)
+	s.expect(t, "redis.connected_replicas", 1)
+	s.expect(t, "redis.uptime_seconds", 86400)
+	s.expect(t, "redis.is_master", 1)
+	s.expect(t, "redis.keyspace_hits", 0)
+	s.expect(t, "redis.keyspace.db0.keys", 100)
+	s.expect(t, "redis.keyspace.db0.expires", 5)
+	s.expect(t, "redis.keyspace.db2.keys", 7)
+
+	// INFO and INFO commandstats are each called once per tick
+	s.expect(t, "redis.commands_processed", 20)
+	s.expect(t, "redis.commands.get.calls", 20)
+	s.expect(t, "redis.commands.get.usec", 0)
+	s.expect(t, "redis.commands.config_get.calls", 0)
+}
+
+func TestRedisAgentAuthFailure(t *testing.T) {
+	r := startFakeRedis(t, "secret", false)
+	defer r.listener.Close()
+
+	agent := newTestRedisAgent(t, map[string]interface{}{
+		"address":  r.listener.Addr().String(),
+		"password": "wrong",
+	})
+	s := newTestSink()
+	err := agent.Tick(context.Background(), s)
+	if err == nil || !strings.Contains(err.Error(), "invalid password") {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+	s.expectMissing(t, "redis.used_memory_bytes")
+}
+
+func TestRedisAgentNoAuth(t *testing.T) {
+	r := startFakeRedis(t, "secret", false)
+	defer r.listener.Close()
+
+	agent := newTestRedisAgent(t, map[string]interface{}{"address": r.listener.Addr().String()})
+	err := agent.Tick(context.Background(), newTestSink())
+	if err == nil || !strings.Contains(err.Error(), "NOAUTH") {
+		t.Errorf("expected the INFO command to be refused, got %v", err)
+	}
+}
+
+func TestRedisAgentTruncatedReply(t *testing.T) {
+	r := startFakeRedis(t, "", true)
+	defer r.listener.Close()
+
+	agent := newTestRedisAgent(t, map[string]interface{}{
+		"address": r.listener.Addr().String(),
+		"timeout": 1,
+	})
+	s := newTestSink()
+	start := time.Now()
+	if err := agent.Tick(context.Background(), s); err == nil {
+		t.Error("expected an error for a truncated reply")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("expected the truncated reply to fail quickly, took %s", elapsed)
+	}
+	s.expectMissing(t, "redis.used_memory_bytes")
+}
+
+func TestRedisCommandReplies(t *testing.T) {
+	for _, c := range []struct {
+		reply    string
+		expected string
+		err      string
+	}{
+		{"+OK\r\n", "OK", ""},
+		{":42\r\n", "42", ""},
+		{"-ERR wrong\r\n", "", "ERR wrong"},
+		{"$5\r\nhello\r\n", "hello", ""},
+		{"$-1\r\n", "", ""},
+		{"$10\r\nhel", "", "EOF"},
+		{"$x\r\n", "", "invalid bulk string length"},
+		{"*1\r\n", "", "unsupported reply type"},
+		{"", "", "EOF"},
+	} {
+		rw := bufio.NewReadWriter(bufio.NewReader(strings.NewReader(c.reply)), bufio.NewWriter(ioutil.Discard))
+		value, err := redisCommand(rw, "PING")
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected %q to give an error containing %q, got %v", c.reply, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", c.reply, err)
+		} else if value != c.expected {
+			t.Errorf("expected %q to give %q, got %q", c.reply, c.expected, value)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// statsdAgent is a statsd server. Metrics received between ticks are
+// aggregated and reported on each tick, so the interval of the agent is the
+// flush interval.
+type statsdAgent struct {
+	statsdAgentSettings
+	config conf.SpoonConfigAgent
+
+	lock      sync.Mutex
+	listening bool
+	lastFlush time.Time
+	buckets   map[string]*statsdBucket
+	gauges    map[string]*statsdBucket
+	badLines  uint64
+}
+
+type statsdAgentSettings struct {
+	UDPAddress  string    `json:"udp_address"`
+	TCPAddress  string    `json:"tcp_address"`
+	Percentiles []float64 `json:"percentiles"`
+	// GaugeExpiry is the number of seconds after which a gauge that has not
+	// been updated is no longer reported
+	GaugeExpiry float64 `json:"gauge_expiry"`
+}
+
+// statsdSample is a single parsed statsd line
+type statsdSample struct {
+	name       string
+	kind       string
+	value      float64
+	member     string
+	relative   bool
+	sampleRate float64
+	tags       sink.Tags
+}
+
+// statsdBucket holds the aggregate of a metric since the last flush. Gauges
+// are kept between flushes, like statsd does, until they expire.
+type statsdBucket struct {
+	path    string
+	kind    string
+	tags    sink.Tags
+	updated time.Time
+	value   float64
+	count   float64
+	values  []float64
+	members map[string]bool
+}
+
+func NewStatsdAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := statsdAgentSettings{}
+	if len(config.SettingsRaw) > 0 {
+		if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse settings: %s", err)
+		}
+	}
+	if s.UDPAddress == "" && s.TCPAddress == "" {
+		s.UDPAddress = "127.0.0.1:8125"
+	}
+	if s.Percentiles == nil {
+		s.Percentiles = []float64{90}
+	}
+	if s.GaugeExpiry == 0 {
+		s.GaugeExpiry = 300
+	} else if s.GaugeExpiry < 0 {
+		return nil, errors.New("statsdAgent 'gauge_expiry' setting must be positive")
+	}
+	for _, p := range s.Percentiles {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("statsdAgent percentile %v must be between 0 and 100", p)
+		}
+	}
+
+	return &statsdAgent{
+		statsdAgentSettings: s,
+		config:              *config,
+		lock:                sync.Mutex{},
+		buckets:             map[string]*statsdBucket{},
+		gauges:              map[string]*statsdBucket{},
+	}, nil
+}
+
+func (a *statsdAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+// Tick starts listening on the first call, and then reports the metrics
+// aggregated since the previous tick. The listeners are closed when the
+// context is cancelled.
+func (a *statsdAgent) Tick(ctx context.Context, s sink.Sink) error {
+	if err := a.listen(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	a.lock.Lock()
+	buckets := a.buckets
+	a.buckets = map[string]*statsdBucket{}
+	elapsed := now.Sub(a.lastFlush).Seconds()
+	a.lastFlush = now
+	badLines := a.badLines
+	a.badLines = 0
+	expiredBefore := now.Add(-time.Duration(a.GaugeExpiry * float64(time.Second)))
+	gauges := make([]statsdBucket, 0, len(a.gauges))
+	for k, g := range a.gauges {
+		if g.updated.Before(expiredBefore) {
+			delete(a.gauges, k)
+			continue
+		}
+		gauges = append(gauges, *g)
+	}
+	a.lock.Unlock()
+
+	s.Count(a.config.Path+".statsd.bad_lines", badLines)
+	for _, g := range gauges {
+		s.Gauge(g.path, g.value, g.tags)
+	}
+	for _, b := range buckets {
+		switch b.kind {
+		case "c":
+			s.Count(b.path+".count", b.value, b.tags)
+			if elapsed > 0 {
+				s.Gauge(b.path+".rate", b.value/elapsed, b.tags)
+			}
+		case "s":
+			s.Gauge(b.path+".count", len(b.members), b.tags)
+		case "ms", "h":
+			a.reportTimer(s, b, elapsed)
+		}
+	}
+	return nil
+}
+
+func (a *statsdAgent) reportTimer(s sink.Sink, b *statsdBucket, elapsed float64) {
+	values := b.values
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	n := len(values)
+	s.Gauge(b.path+".count", b.count, b.tags)
+	if elapsed > 0 {
+		s.Gauge(b.path+".count_ps", b.count/elapsed, b.tags)
+	}
+	s.Gauge(b.path+".lower", values[0], b.tags)
+	s.Gauge(b.path+".upper", values[n-1], b.tags)
+	s.Gauge(b.path+".sum", sum, b.tags)
+	s.Gauge(b.path+".mean", sum/float64(n), b.tags)
+	if n%2 == 1 {
+		s.Gauge(b.path+".median", values[n/2], b.tags)
+	} else {
+		s.Gauge(b.path+".median", (values[n/2-1]+values[n/2])/2, b.tags)
+	}
+	for _, p := range a.Percentiles {
+		// nearest rank
+		i := int(math.Ceil(p/100*float64(n))) - 1
+		if i < 0 {
+			i = 0
+		}
+		name := strings.Replace(strconv.FormatFloat(p, 'f', -1, 64), ".", "_", -1)
+		s.Gauge(b.path+".upper_"+name, values[i], b.tags)
+	}
+}
+
+// listen opens the configured listeners if they aren't open yet
+func (a *statsdAgent) listen(ctx context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.listening {
+		return nil
+	}
+
+	var udpConn net.PacketConn
+	var tcpListener net.Listener
+	var err error
+	if a.UDPAddress != "" {
+		if udpConn, err = net.ListenPacket("udp", a.UDPAddress); err != nil {
+			return fmt.Errorf("failed to listen on udp %s: %s", a.UDPAddress, err)
+		}
+		log.Printf("Listening for statsd metrics on udp %s", a.UDPAddress)
+	}
+	if a.TCPAddress != "" {
+		if tcpListener, err = net.Listen("tcp", a.TCPAddress); err != nil {
+			if udpConn != nil {
+				udpConn.Close()
+			}
+			return fmt.Errorf("failed to listen on tcp %s: %s", a.TCPAddress, err)
+		}
+		log.Printf("Listening for statsd metrics on tcp %s", a.TCPAddress)
+	}
+
+	a.listening = true
+	a.lastFlush = time.Now()
+
+	go func() {
+		<-ctx.Done()
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		if tcpListener != nil {
+			tcpListener.Close()
+		}
+	}()
+	if udpConn != nil {
+		go a.serveUDP(udpConn)
+	}
+	if tcpListener != nil {
+		go a.serveTCP(ctx, tcpListener)
+	}
+	return nil
+}
+
+func (a *statsdAgent) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			a.handleLine(line)
+		}
+	}
+}
+
+func (a *statsdAgent) serveTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-done:
+				}
+			}()
+			defer conn.Close()
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				a.handleLine(scanner.Text())
+			}
+		}()
+	}
+}
+
+func (a *statsdAgent) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	sample, err := parseStatsdLine(line)
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if err != nil {
+		a.badLines++
+		return
+	}
+	a.add(sample)
+}
+
+// add aggregates the sample into its bucket. It must be called with the lock
+// held.
+func (a *statsdAgent) add(sample statsdSample) {
+	path := a.config.Path
+	for _, part := range strings.Split(sample.name, ".") {
+		if part = sanitizePathPart(part); part != "" {
+			path += "." + part
+		}
+	}
+	for _, k := range sortedTagKeys(sample.tags) {
+		path += ".{" + k + "}"
+	}
+	key := sample.kind + ":" + sink.ExpandPath(path, sample.tags)
+
+	if sample.kind == "g" {
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &statsdBucket{path: path, kind: sample.kind, tags: sample.tags}
+			a.gauges[key] = g
+		}
+		if sample.relative {
+			g.value += sample.value
+		} else {
+			g.value = sample.value
+		}
+		g.updated = time.Now()
+		return
+	}
+
+	b, ok := a.buckets[key]
+	if !ok {
+		b = &statsdBucket{path: path, kind: sample.kind, tags: sample.tags, members: map[string]bool{}}
+		a.buckets[key] = b
+	}
+	switch sample.kind {
+	case "c":
+		b.value += sample.value / sample.sampleRate
+	case "s":
+		b.members[sample.member] = true
+	case "ms", "h":
+		b.values = append(b.values, sample.value)
+		b.count += 1 / sample.sampleRate
+	}
+}
+
+// parseStatsdLine parses a line of the statsd protocol:
+//
+//	name:value|type[|@sample_rate][|#tag:value,tag]
+//
+// The type is one of c, g, ms, h, or s. Gauge values with a leading sign are
+// changes to the current value. Tags use the dogstatsd extension, and tags
+// without a name are ignored.
+func parseStatsdLine(line string) (statsdSample, error) {
+	sample := statsdSample{sampleRate: 1}
+	colon := strings.LastIndex(line, ":")
+	pipe := strings.Index(line, "|")
+	// dogstatsd tags may contain colons, so find the value before them
+	if pipe > 0 {
+		colon = strings.LastIndex(line[:pipe], ":")
+	}
+	if colon <= 0 || pipe < colon {
+		return sample, errors.New("missing name or type")
+	}
+	sample.name = line[:colon]
+	valueString := line[colon+1 : pipe]
+	fields := strings.Split(line[pipe+1:], "|")
+	sample.kind = fields[0]
+
+	for _, field := range fields[1:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			rate, err := strconv.ParseFloat(field[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample, fmt.Errorf("invalid sample rate '%s'", field)
+			}
+			sample.sampleRate = rate
+		case strings.HasPrefix(field, "#"):
+			sample.tags = sink.Tags{}
+			for _, tag := range strings.Split(field[1:], ",") {
+				parts := strings.SplitN(tag, ":", 2)
+				key := sanitizePathPart(parts[0])
+				if key == "" {
+					continue
+				}
+				if len(parts) == 2 {
+					sample.tags[key] = sanitizePathPart(parts[1])
+				} else {
+					sample.tags[key] = "true"
+				}
+			}
+		}
+	}
+
+	switch sample.kind {
+	case "s":
+		sample.member = valueString
+		return sample, nil
+	case "g":
+		sample.relative = strings.HasPrefix(valueString, "+") || strings.HasPrefix(valueString, "-")
+	case "c", "ms", "h":
+	default:
+		return sample, fmt.Errorf("unknown type '%s'", sample.kind)
+	}
+	value, err := strconv.ParseFloat(valueString, 64)
+	if err != nil {
+		return sample, fmt.Errorf("invalid value '%s'", valueString)
+	}
+	sample.value = value
+	return sample, nil
+}
+
+func sortedTagKeys(tags sink.Tags) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,65 @@
+package agents
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestParseStatsdLineIgnoresEmptyTags(t *testing.T) {
+	sample, err := parseStatsdLine("requests:1|c|#,env:prod,")
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(sample.tags) != 1 || sample.tags["env"] != "prod" {
+		t.Errorf("expected only the env tag, got %v", sample.tags)
+	}
+
+	sample, err = parseStatsdLine("requests:1|c|#")
+	if err != nil {
+		t.Fatalf("failed to parse: %s", err)
+	}
+	if len(sample.tags) != 0 {
+		t.Errorf("expected no tags, got %v", sample.tags)
+	}
+}
+
+func TestStatsdAgentEmptyTagSection(t *testing.T) {
+	agent, err := NewStatsdAgent(testAgentConfig(t, "statsd", "statsd", map[string]interface{}{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := agent.(*statsdAgent)
+	a.handleLine("queue:5|g|#")
+	for key, g := range a.gauges {
+		if g.path != "statsd.queue" {
+			t.Errorf("expected path statsd.queue for %s, got %s", key, g.path)
+		}
+	}
+}
+
+func TestStatsdAgentExpiresGauges(t *testing.T) {
+	agent, err := NewStatsdAgent(testAgentConfig(t, "statsd", "statsd", map[string]interface{}{
+		"gauge_expiry": 60,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := agent.(*statsdAgent)
+	a.handleLine("fresh:1|g")
+	a.handleLine("old:2|g")
+	a.gauges["g:statsd.old"].updated = time.Now().Add(-2 * time.Minute)
+
+	// report without listening, as Tick would
+	a.listening = true
+	s := newTestSink()
+	if err := a.Tick(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, "statsd.fresh", 1)
+	s.expectMissing(t, "statsd.old")
+	if _, ok := a.gauges["g:statsd.old"]; ok {
+		t.Error("expected the expired gauge to be forgotten")
+	}
+}
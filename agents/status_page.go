@@ -0,0 +1,45 @@
+package agents
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"golang.org/x/net/context"
+)
+
+// statusPageSettings are the settings shared by agents which scrape a status
+// page over http.
+type statusPageSettings struct {
+	URL string `json:"url"`
+	// Timeout is in seconds and is capped at the interval of the agent
+	Timeout float64 `json:"timeout"`
+}
+
+// fetchStatusPage requests the status page and returns the body. The request
+// is cancelled after the timeout, or the interval of the agent if that is
+// shorter.
+func fetchStatusPage(ctx context.Context, config conf.SpoonConfigAgent, s statusPageSettings) ([]byte, error) {
+	timeout := time.Duration(float64(config.Interval) * float64(time.Second))
+	if s.Timeout > 0 && time.Duration(s.Timeout*float64(time.Second)) < timeout {
+		timeout = time.Duration(s.Timeout * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", s.URL, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
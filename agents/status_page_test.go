@@ -0,0 +1,48 @@
+package agents
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// statusPageServer serves each of the fixture files in turn, repeating the
+// last one once they run out
+func statusPageServer(t *testing.T, files ...string) *httptest.Server {
+	t.Helper()
+	bodies := [][]byte{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, data)
+	}
+	lock := sync.Mutex{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		w.Write(bodies[0])
+		if len(bodies) > 1 {
+			bodies = bodies[1:]
+		}
+	}))
+}
+
+func TestFetchStatusPageNon200(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	config := testAgentConfig(t, "nginx", "nginx", map[string]interface{}{})
+	_, err := fetchStatusPage(context.Background(), *config, statusPageSettings{URL: server.URL})
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected an error with the status, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package agents
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// streamAgentMaxBuffered is the number of metrics kept between ticks. If the
+// child process writes more than this, the oldest metrics are dropped.
+const streamAgentMaxBuffered = 10000
+
+// streamAgentMinBackoff is the delay before the first restart of the child
+const streamAgentMinBackoff = time.Second
+
+// streamAgentDrainTimeout is how long the output of the child is read for after
+// it exits. A grandchild may have inherited the pipes and keep them open.
+const streamAgentDrainTimeout = time.Second
+
+type streamAgent struct {
+	streamAgentSettings
+	config conf.SpoonConfigAgent
+
+	// ctx is cancelled when the agent is stopped, which stops the child
+	ctx        context.Context
+	cancel     context.CancelFunc
+	supervised chan struct{}
+
+	startOnce sync.Once
+	lock      sync.Mutex
+	buffered  []cmdMetric
+	dropped   int
+	restarts  uint64
+	running   bool
+}
+
+type streamAgentSettings struct {
+	Command []string `json:"cmd"`
+	// Format is the format of each output line: lines (the default) or graphite
+	Format string `json:"format"`
+	// MaxBackoff is the longest delay in seconds between restarts of the child
+	MaxBackoff float64 `json:"max_backoff"`
+}
+
+func NewStreamAgent(config *conf.SpoonConfigAgent) (Agent, error) {
+	s := streamAgentSettings{}
+	if err := json.Unmarshal(config.SettingsRaw, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse settings: %s", err)
+	}
+
+	if len(s.Command) < 1 {
+		return nil, errors.New("streamAgent 'cmd' setting must have at least one item")
+	}
+
+	s.Format = strings.ToLower(s.Format)
+	switch s.Format {
+	case "":
+		s.Format = cmdFormatLines
+	case cmdFormatLines, cmdFormatGraphite:
+	default:
+		return nil, fmt.Errorf("streamAgent 'format' setting '%s' must be either lines or graphite", s.Format)
+	}
+
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = 60
+	} else if s.MaxBackoff < 0 {
+		return nil, errors.New("streamAgent 'max_backoff' setting must be positive")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamAgent{
+		streamAgentSettings: s,
+		config:              *config,
+		ctx:                 ctx,
+		cancel:              cancel,
+		supervised:          make(chan struct{}),
+		lock:                sync.Mutex{},
+	}, nil
+}
+
+func (a *streamAgent) GetConfig() conf.SpoonConfigAgent {
+	return a.config
+}
+
+func (a *streamAgent) hasOwnTimestamps() bool {
+	return a.Format == cmdFormatGraphite
+}
+
+// Tick starts the child process on the first call, and then reports whatever
+// metrics it has written since the previous tick. The child keeps running
+// between ticks until the agent is stopped.
+func (a *streamAgent) Tick(ctx context.Context, s sink.Sink) error {
+	a.startOnce.Do(func() {
+		go func() {
+			defer close(a.supervised)
+			a.supervise(a.ctx)
+		}()
+	})
+
+	a.lock.Lock()
+	metrics := a.buffered
+	dropped := a.dropped
+	restarts := a.restarts
+	running := a.running
+	a.buffered = nil
+	a.dropped = 0
+	a.lock.Unlock()
+
+	if dropped > 0 {
+		log.Printf("%s: dropped %d metrics because more than %d were written between ticks", a.config.Path, dropped, streamAgentMaxBuffered)
+	}
+
+	ts := newTimestampedSink(s, time.Now())
+	runningValue := 0
+	if running {
+		runningValue = 1
+	}
+	ts.Gauge(a.config.Path+".running", runningValue)
+	ts.Gauge(a.config.Path+".restarts", restarts)
+	for _, m := range metrics {
+		path := m.path
+		if !m.absolute {
+			path = a.config.Path + ".values." + path
+		}
+		ts.at(m.timestamp).Gauge(path, m.value, m.tags)
+	}
+	return ts.Flush()
+}
+
+// stop kills the child process and waits for the supervisor to exit
+func (a *streamAgent) stop() {
+	a.cancel()
+	a.startOnce.Do(func() { close(a.supervised) })
+	<-a.supervised
+}
+
+// supervise runs the child process until the context is cancelled, restarting
+// it with an exponential backoff whenever it exits. The backoff is reset if the
+// child ran for longer than the maximum backoff.
+func (a *streamAgent) supervise(ctx context.Context) {
+	maxBackoff := time.Duration(a.MaxBackoff * float64(time.Second))
+	backoff := streamAgentMinBackoff
+	for {
+		start := time.Now()
+		err := a.run(ctx)
+		if ctx.Err() != nil {
+			log.Printf("%s: stopped %v command", a.config.Path, a.Command[0])
+			return
+		}
+		if err != nil {
+			log.Printf("%s: %v command failed: %s", a.config.Path, a.Command[0], err)
+		} else {
+			log.Printf("%s: %v command exited", a.config.Path, a.Command[0])
+		}
+
+		if time.Since(start) > maxBackoff {
+			backoff = streamAgentMinBackoff
+		}
+		log.Printf("%s: restarting %v command in %v", a.config.Path, a.Command[0], backoff)
+		if !sleepContext(ctx, backoff) {
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+
+		a.lock.Lock()
+		a.restarts++
+		a.lock.Unlock()
+	}
+}
+
+// run starts the child process and reads its output until it exits
+func (a *streamAgent) run(ctx context.Context) error {
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
+		return err
+	}
+	defer stdoutR.Close()
+	defer stderrR.Close()
+
+	cmd := exec.CommandContext(ctx, a.Command[0], a.Command[1:]...)
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	err = cmd.Start()
+	// the child has its own copy of the write ends, so the readers see EOF
+	// once it and anything it started have closed them
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		return err
+	}
+
+	a.setRunning(true)
+	defer a.setRunning(false)
+
+	drained := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.readMetrics(stdoutR)
+	}()
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(stderrR)
+		for scanner.Scan() {
+			log.Printf("%s: %s", a.config.Path, scanner.Text())
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	err = cmd.Wait()
+
+	// read whatever is left in the pipes, but don't wait forever for a
+	// grandchild that is still holding them open
+	select {
+	case <-drained:
+	case <-time.After(streamAgentDrainTimeout):
+		stdoutR.Close()
+		stderrR.Close()
+		<-drained
+	}
+	return err
+}
+
+func (a *streamAgent) readMetrics(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var metrics []cmdMetric
+		var err error
+		if a.Format == cmdFormatGraphite {
+			metrics, err = parseCmdGraphite(strings.NewReader(scanner.Text()))
+		} else {
+			metrics, err = parseCmdLines(strings.NewReader(scanner.Text()))
+		}
+		if err != nil || len(metrics) == 0 {
+			continue
+		}
+
+		a.lock.Lock()
+		a.buffered = append(a.buffered, metrics...)
+		if over := len(a.buffered) - streamAgentMaxBuffered; over > 0 {
+			a.buffered = a.buffered[over:]
+			a.dropped += over
+		}
+		a.lock.Unlock()
+	}
+}
+
+func (a *streamAgent) setRunning(running bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.running = running
+}
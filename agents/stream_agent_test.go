@@ -0,0 +1,91 @@
+package agents
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStreamAgentRunIgnoresGrandchildHoldingPipes(t *testing.T) {
+	agent, err := NewStreamAgent(testAgentConfig(t, "stream", "stream", map[string]interface{}{
+		"cmd": []string{"sh", "-c", "sleep 30 & echo .a 1"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := agent.(*streamAgent)
+
+	done := make(chan error)
+	go func() { done <- a.run(context.Background()) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return after the child exited")
+	}
+
+	s := newTestSink()
+	if err := a.Tick(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, "stream.values.a", 1)
+	a.stop()
+}
+
+func TestStreamAgentStop(t *testing.T) {
+	agent, err := NewStreamAgent(testAgentConfig(t, "stream", "stream", map[string]interface{}{
+		"cmd": []string{"sh", "-c", "echo .a 1; exec sleep 30"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := agent.(*streamAgent)
+
+	// the child must outlive the context of the tick that started it
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := a.Tick(ctx, newTestSink()); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	s := newTestSink()
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := a.Tick(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.metrics["stream.values.a"]; ok {
+			break
+		}
+	}
+	s.expect(t, "stream.running", 1)
+	s.expect(t, "stream.values.a", 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		a.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("stop did not return")
+	}
+	if err := a.Tick(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	s.expect(t, "stream.running", 0)
+}
+
+func TestStreamAgentStopBeforeStart(t *testing.T) {
+	agent, err := NewStreamAgent(testAgentConfig(t, "stream", "stream", map[string]interface{}{
+		"cmd": []string{"true"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.(*streamAgent).stop()
+}
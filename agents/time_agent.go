@@ -5,6 +5,7 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type timeAgent struct {
@@ -19,7 +20,7 @@ func (a *timeAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *timeAgent) Tick(s sink.Sink) error {
+func (a *timeAgent) Tick(ctx context.Context, s sink.Sink) error {
 	s.Gauge(a.config.Path, float64(time.Now().Unix()))
 	return nil
 }
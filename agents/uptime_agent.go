@@ -5,6 +5,7 @@ import (
 
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 type uptimeAgent struct {
@@ -19,7 +20,7 @@ func (a *uptimeAgent) GetConfig() conf.SpoonConfigAgent {
 	return a.config
 }
 
-func (a *uptimeAgent) Tick(s sink.Sink) error {
+func (a *uptimeAgent) Tick(ctx context.Context, s sink.Sink) error {
 	ut, err := host.Uptime()
 	if err != nil {
 		return err
@@ -117,6 +117,53 @@ func InterpolateBasePath(p string) (output string, err error) {
 	return
 }
 
+// BuildActiveSink builds the sink that agents should report to. When the
+// 'sinks' list is used, each entry is wrapped in a single MultiSink. Any global
+// tags are added to every metric.
+func BuildActiveSink(cfg *conf.SpoonConfig) (sink.Sink, error) {
+	if len(cfg.Sinks) > 0 {
+		sinks := make([]conf.SpoonConfigSink, len(cfg.Sinks))
+		for i, c := range cfg.Sinks {
+			sinks[i] = resolveSpoolPath(cfg, c)
+		}
+		s, err := sink.NewMultiSink(sinks)
+		if err != nil {
+			return nil, err
+		}
+		return sink.WithTags(s, cfg.Tags), nil
+	}
+	sinkCfg := resolveSpoolPath(cfg, cfg.Sink)
+	s, err := sink.BuildSink(&sinkCfg)
+	if err != nil {
+		return nil, err
+	}
+	return sink.WithTags(s.(sink.Sink), cfg.Tags), nil
+}
+
+// sinkFingerprint returns a string which only changes if the config of the
+// active sink changes
+func sinkFingerprint(cfg *conf.SpoonConfig) string {
+	parts := []string{cfg.Sink.Fingerprint()}
+	for _, c := range cfg.Sinks {
+		parts = append(parts, c.Fingerprint())
+	}
+	tags, _ := json.Marshal(cfg.Tags)
+	parts = append(parts, string(tags))
+	return strings.Join(parts, "\n")
+}
+
+// resolveSpoolPath returns a copy of the sink config with a relative spool
+// metric path prefixed by the base path. The config itself is left alone so
+// that it can still be compared with a freshly loaded one.
+func resolveSpoolPath(cfg *conf.SpoonConfig, sinkCfg conf.SpoonConfigSink) conf.SpoonConfigSink {
+	if sinkCfg.Spool != nil && len(sinkCfg.Spool.MetricPath) > 0 && sinkCfg.Spool.MetricPath[0] == '.' {
+		spool := *sinkCfg.Spool
+		spool.MetricPath = cfg.BasePath + spool.MetricPath
+		sinkCfg.Spool = &spool
+	}
+	return sinkCfg
+}
+
 func CleanAndValidate(cfg *conf.SpoonConfig) (err error) {
 	// check base path
 	if cfg.BasePath != "" {
@@ -136,10 +183,27 @@ func CleanAndValidate(cfg *conf.SpoonConfig) (err error) {
 		}
 	}
 
+	// check shutdown timeout
+	if cfg.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout cannot be < 0")
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 10
+	}
+
 	// check Sink config
-	_, err = sink.BuildSink(&cfg.Sink)
+	if len(cfg.Sinks) > 0 && cfg.Sink.Type != "" {
+		return fmt.Errorf("cannot use both 'sink' and 'sinks' in the same config")
+	}
+	// the sink is only checked here rather than built, since building it could
+	// bind a port or spool directory that the running sink still holds
+	if len(cfg.Sinks) > 0 {
+		err = sink.ValidateMultiSink(cfg.Sinks)
+	} else {
+		err = sink.ValidateSink(&cfg.Sink)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to build sink: %s", err)
+		return fmt.Errorf("invalid sink: %s", err)
 	}
 
 	for _, c := range cfg.Agents {
@@ -49,6 +49,14 @@ func GenerateExampleConfig() *SpoonConfig {
 					},
 				},
 			},
+			SpoonConfigAgent{
+				internalSpoonConfigAgent{
+					Type:     "load",
+					Interval: float32(60),
+					Path:     ".load",
+					Enabled:  true,
+				},
+			},
 			SpoonConfigAgent{
 				internalSpoonConfigAgent{
 					Type:     "mem",
@@ -4,9 +4,12 @@ import "encoding/json"
 
 // SpoonConfig is the definition of the json config structure
 type SpoonConfig struct {
-	BasePath string             `json:"base_path"`
-	Agents   []SpoonConfigAgent `json:"agents"`
-	Sink     SpoonConfigSink    `json:"sink"`
+	BasePath        string             `json:"base_path"`
+	Tags            map[string]string  `json:"tags,omitempty"`
+	ShutdownTimeout float32            `json:"shutdown_timeout,omitempty"`
+	Agents          []SpoonConfigAgent `json:"agents"`
+	Sink            SpoonConfigSink    `json:"sink"`
+	Sinks           []SpoonConfigSink  `json:"sinks,omitempty"`
 }
 
 type internalSpoonConfigAgent struct {
@@ -23,6 +26,13 @@ type SpoonConfigAgent struct {
 	internalSpoonConfigAgent
 }
 
+// Fingerprint returns a string which is equal for two agent configs only if
+// they are equivalent
+func (s *SpoonConfigAgent) Fingerprint() string {
+	b, _ := json.Marshal(s.internalSpoonConfigAgent)
+	return string(b)
+}
+
 func (s *SpoonConfigAgent) MarshalJSON() ([]byte, error) {
 	b, err := json.Marshal(s.Settings)
 	if err != nil {
@@ -33,9 +43,22 @@ func (s *SpoonConfigAgent) MarshalJSON() ([]byte, error) {
 }
 
 type internalSpoonConfigSink struct {
-	Type        string          `json:"type"`
-	SettingsRaw json.RawMessage `json:"settings,omitempty"`
-	Settings    interface{}     `json:"-"`
+	Type        string            `json:"type"`
+	SettingsRaw json.RawMessage   `json:"settings,omitempty"`
+	Settings    interface{}       `json:"-"`
+	Include     []string          `json:"include,omitempty"`
+	Exclude     []string          `json:"exclude,omitempty"`
+	Spool       *SpoonConfigSpool `json:"spool,omitempty"`
+}
+
+// SpoonConfigSpool is a sub structure of SpoonConfigSink which configures an
+// on-disk buffer for metrics that could not be delivered
+type SpoonConfigSpool struct {
+	Directory     string  `json:"directory"`
+	MaxBytes      int64   `json:"max_bytes,omitempty"`
+	MaxAge        float64 `json:"max_age,omitempty"`
+	RetryInterval float64 `json:"retry_interval,omitempty"`
+	MetricPath    string  `json:"metric_path,omitempty"`
 }
 
 // SpoonConfigSink is a sub structure of SpoonConfig
@@ -43,6 +66,13 @@ type SpoonConfigSink struct {
 	internalSpoonConfigSink
 }
 
+// Fingerprint returns a string which is equal for two sink configs only if
+// they are equivalent
+func (s *SpoonConfigSink) Fingerprint() string {
+	b, _ := json.Marshal(s.internalSpoonConfigSink)
+	return string(b)
+}
+
 func (s *SpoonConfigSink) MarshalJSON() ([]byte, error) {
 	b, err := json.Marshal(s.Settings)
 	if err != nil {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// closeCountingSink counts how many times the sink it wraps is closed
+type closeCountingSink struct {
+	sink.Sink
+	closed int
+}
+
+func (s *closeCountingSink) Close() error {
+	s.closed++
+	return s.Sink.Close()
+}
+
+func TestReloadUnchangedConfigKeepsSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spoon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configPath := filepath.Join(dir, "spoon.json")
+	config := `{
+		"base_path": "host",
+		"agents": [],
+		"sink": {
+			"type": "graphite",
+			"settings": {"address": "127.0.0.1:2003"},
+			"spool": {"directory": "` + filepath.Join(dir, "spool") + `", "metric_path": ".spool"}
+		}
+	}`
+	if err := ioutil.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := Load(&configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CleanAndValidate(current); err != nil {
+		t.Fatal(err)
+	}
+	activeSink, err := BuildActiveSink(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Sink.Spool.MetricPath != ".spool" {
+		t.Errorf("expected the config to be left alone, got metric path %s", current.Sink.Spool.MetricPath)
+	}
+
+	counting := &closeCountingSink{Sink: activeSink}
+	switchSink := sink.NewSwitchSink(counting)
+	defer switchSink.Close()
+	runner := newAgentRunner(context.Background(), switchSink)
+
+	if _, err := reloadConfig(configPath, current, switchSink, runner); err != nil {
+		t.Fatal(err)
+	}
+	if counting.closed != 0 {
+		t.Error("expected the sink to be kept when the config has not changed")
+	}
+}
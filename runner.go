@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/AstromechZA/spoon/agents"
+	"github.com/AstromechZA/spoon/conf"
+	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
+)
+
+// agentRunner keeps track of the running agents so that they can be started
+// and stopped individually when the config is reloaded. Agents whose config
+// has not changed keep running, along with any state they hold.
+type agentRunner struct {
+	ctx     context.Context
+	sink    sink.Sink
+	running map[string][]*runningAgent
+}
+
+type runningAgent struct {
+	agent  agents.Agent
+	cancel context.CancelFunc
+	done   <-chan struct{}
+}
+
+func newAgentRunner(ctx context.Context, s sink.Sink) *agentRunner {
+	return &agentRunner{
+		ctx:     ctx,
+		sink:    s,
+		running: make(map[string][]*runningAgent),
+	}
+}
+
+// resolveAgentConfigs returns the agent configs with relative paths prefixed
+// by the base path
+func resolveAgentConfigs(cfg *conf.SpoonConfig) []conf.SpoonConfigAgent {
+	output := make([]conf.SpoonConfigAgent, len(cfg.Agents))
+	for i, c := range cfg.Agents {
+		if len(c.Path) > 0 && c.Path[0] == '.' {
+			c.Path = cfg.BasePath + c.Path
+		}
+		output[i] = c
+	}
+	return output
+}
+
+// apply brings the running agents in line with the given configs. Agents that
+// have been removed or changed are stopped and any new or changed agents are
+// started. If any new agent fails to build, nothing is changed.
+func (r *agentRunner) apply(cfgs []conf.SpoonConfigAgent) error {
+	// work out which running agents can be kept
+	keep := make(map[string][]*runningAgent)
+	toBuild := []conf.SpoonConfigAgent{}
+	available := make(map[string]int)
+	for k, v := range r.running {
+		available[k] = len(v)
+	}
+	for _, c := range cfgs {
+		key := c.Fingerprint()
+		if available[key] > 0 {
+			available[key]--
+			keep[key] = append(keep[key], r.running[key][available[key]])
+			continue
+		}
+		toBuild = append(toBuild, c)
+	}
+
+	// build the new agents before stopping anything
+	built := make([]agents.Agent, len(toBuild))
+	for i, c := range toBuild {
+		a, err := agents.BuildAgent(&c)
+		if err != nil {
+			return fmt.Errorf("failed to build %s agent %s: %s", c.Type, c.Path, err)
+		}
+		built[i] = a
+	}
+
+	// stop agents that are no longer needed
+	for key, list := range r.running {
+		for _, ra := range list[:available[key]] {
+			ac := ra.agent.GetConfig()
+			log.Printf("Stopping %s agent %s", ac.Type, ac.Path)
+			ra.cancel()
+			<-ra.done
+		}
+	}
+	r.running = keep
+
+	// and start the new ones
+	for _, a := range built {
+		if err := r.start(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *agentRunner) start(a agents.Agent) error {
+	ctx, cancel := context.WithCancel(r.ctx)
+	done, err := agents.SpawnAgent(ctx, a, r.sink)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("Failed to spawn agent %v: %s", a, err)
+	}
+	ac := a.GetConfig()
+	key := ac.Fingerprint()
+	r.running[key] = append(r.running[key], &runningAgent{agent: a, cancel: cancel, done: done})
+	return nil
+}
+
+// doneChannels returns the done channel for every running agent
+func (r *agentRunner) doneChannels() []<-chan struct{} {
+	output := []<-chan struct{}{}
+	for _, list := range r.running {
+		for _, ra := range list {
+			output = append(output, ra.done)
+		}
+	}
+	return output
+}
@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"sync"
+	"time"
+)
+
+// metricBatch is a generic Batch implementation which buffers metrics in
+// memory and hands them all to a send function when flushed.
+type metricBatch struct {
+	metricRecorder
+	lock      sync.Mutex
+	timestamp time.Time
+	metrics   []Metric
+	send      func([]Metric) error
+}
+
+func newMetricBatch(timestamp time.Time, send func([]Metric) error) *metricBatch {
+	b := &metricBatch{
+		lock:      sync.Mutex{},
+		timestamp: timestamp,
+		send:      send,
+	}
+	b.metricRecorder = metricRecorder{record: b.add}
+	return b
+}
+
+// add appends a metric to the batch using the batch timestamp
+func (b *metricBatch) add(m Metric) {
+	m.Timestamp = b.timestamp.UnixNano()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.metrics = append(b.metrics, m)
+}
+
+// Close flushes the batch
+func (b *metricBatch) Close() error {
+	return b.Flush()
+}
+
+// Flush sends the collected metrics and empties the batch
+func (b *metricBatch) Flush() error {
+	b.lock.Lock()
+	metrics := b.metrics
+	b.metrics = nil
+	b.lock.Unlock()
+
+	if len(metrics) == 0 {
+		return nil
+	}
+	return b.send(metrics)
+}
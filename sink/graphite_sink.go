@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// graphiteReconnectInterval is the minimum time between connection attempts
+// after the connection to Carbon has failed.
+const graphiteReconnectInterval = 10 * time.Second
+
+// GraphiteSink sends metrics to a Carbon plaintext listener over TCP. A
+// single connection is shared amongst all agents and each agent tick is
+// written as a single batch. Tags are folded into the metric paths.
+type GraphiteSink struct {
+	GraphiteSinkSettings
+	metricRecorder
+	lock          sync.Mutex
+	conn          net.Conn
+	lastReconnect time.Time
+}
+
+type GraphiteSinkSettings struct {
+	Address string  `json:"address"`
+	Prefix  string  `json:"prefix"`
+	Timeout float64 `json:"timeout"`
+}
+
+func NewGraphiteSink(cfg *conf.SpoonConfigSink) (*GraphiteSink, error) {
+	s, err := parseGraphiteSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sink := &GraphiteSink{
+		GraphiteSinkSettings: s,
+		lock:                 sync.Mutex{},
+	}
+	sink.metricRecorder = metricRecorder{record: sink.recordNow}
+	return sink, nil
+}
+
+func parseGraphiteSettings(cfg *conf.SpoonConfigSink) (GraphiteSinkSettings, error) {
+	s := GraphiteSinkSettings{
+		Timeout: 5,
+	}
+	if err := json.Unmarshal(cfg.SettingsRaw, &s); err != nil {
+		return s, fmt.Errorf("failed to parse graphite settings: %s", err)
+	}
+	if s.Address == "" {
+		return s, fmt.Errorf("graphite sink settings missing 'address'")
+	}
+	if _, _, err := net.SplitHostPort(s.Address); err != nil {
+		return s, fmt.Errorf("graphite sink 'address' is invalid: %s", err)
+	}
+	if s.Timeout <= 0 {
+		return s, fmt.Errorf("graphite sink 'timeout' must be > 0")
+	}
+	return s, nil
+}
+
+// recordNow sends a single metric to Carbon immediately
+func (s *GraphiteSink) recordNow(m Metric) {
+	m.Timestamp = time.Now().UnixNano()
+	if err := s.send([]Metric{m}); err != nil {
+		log.Printf("Graphite sink error: %s", err)
+	}
+}
+
+// NewBatch returns a batch which will be written to Carbon in one go
+func (s *GraphiteSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, s.send)
+}
+
+// Close closes the connection to Carbon
+func (s *GraphiteSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *GraphiteSink) timeout() time.Duration {
+	return time.Duration(s.Timeout * float64(time.Second))
+}
+
+func (s *GraphiteSink) formatLines(metrics []Metric) []byte {
+	buf := bytes.Buffer{}
+	for _, m := range metrics {
+		if s.Prefix != "" {
+			buf.WriteString(s.Prefix)
+			buf.WriteByte('.')
+		}
+		buf.WriteString(ExpandPath(m.Path, m.Tags))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(m.Value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(m.Timestamp/int64(time.Second), 10))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func (s *GraphiteSink) send(metrics []Metric) error {
+	data := s.formatLines(collapseSets(metrics))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		if time.Since(s.lastReconnect) < graphiteReconnectInterval {
+			return fmt.Errorf("dropped %d metrics because carbon is unavailable", len(metrics))
+		}
+		s.lastReconnect = time.Now()
+		conn, err := net.DialTimeout("tcp", s.Address, s.timeout())
+		if err != nil {
+			return fmt.Errorf("failed to connect to carbon at %s: %s", s.Address, err)
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout()))
+	if _, err := s.conn.Write(data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return fmt.Errorf("failed to write %d metrics to carbon: %s", len(metrics), err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// carbonTestServer accepts connections and passes on each line it receives.
+// The number of connections accepted is sent on accepted.
+func carbonTestServer(t *testing.T) (net.Listener, chan string, chan int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 100)
+	accepted := make(chan int, 10)
+	go func() {
+		for n := 1; ; n++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- n
+			go func() {
+				defer conn.Close()
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	return l, lines, accepted
+}
+
+func newTestGraphiteSink(t *testing.T, address string) *GraphiteSink {
+	raw, err := json.Marshal(map[string]interface{}{"address": address, "prefix": "spoon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &conf.SpoonConfigSink{}
+	cfg.Type = "graphite"
+	cfg.SettingsRaw = raw
+	s, err := NewGraphiteSink(cfg)
+	if err != nil {
+		t.Fatalf("failed to build sink: %s", err)
+	}
+	return s
+}
+
+func expectLines(t *testing.T, lines chan string, expected ...string) {
+	t.Helper()
+	for _, e := range expected {
+		select {
+		case line := <-lines:
+			if line != e {
+				t.Errorf("expected line %q, got %q", e, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for line %q", e)
+		}
+	}
+}
+
+func TestGraphiteSinkWritesLines(t *testing.T) {
+	l, lines, _ := carbonTestServer(t)
+	defer l.Close()
+	s := newTestGraphiteSink(t, l.Addr().String())
+	defer s.Close()
+
+	b := s.NewBatch(time.Unix(1500000000, 0))
+	b.Gauge("host.cpu.{cpu}.cpu_percent", 12.5, Tags{"cpu": "0", "role": "web"})
+	b.Count("host.net.rx_bytes_delta", 300)
+	b.Set("host.users", "alice")
+	b.Set("host.users", "bob")
+	b.Set("host.users", "alice")
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, lines,
+		"spoon.host.cpu.0.cpu_percent 12.5 1500000000",
+		"spoon.host.net.rx_bytes_delta 300 1500000000",
+		"spoon.host.users 2 1500000000",
+	)
+}
+
+func TestGraphiteSinkReconnectsAfterInterval(t *testing.T) {
+	l, lines, accepted := carbonTestServer(t)
+	defer l.Close()
+	s := newTestGraphiteSink(t, l.Addr().String())
+	defer s.Close()
+
+	send := func(value int) error {
+		b := s.NewBatch(time.Unix(1500000000, 0))
+		b.Gauge("a", value)
+		return b.Flush()
+	}
+	if err := send(1); err != nil {
+		t.Fatal(err)
+	}
+	expectLines(t, lines, "spoon.a 1 1500000000")
+	<-accepted
+
+	// break the connection so that the next write fails
+	s.lock.Lock()
+	s.conn.Close()
+	s.lock.Unlock()
+	if err := send(2); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+
+	// no reconnect is attempted until the interval has passed
+	if err := send(3); err == nil {
+		t.Fatal("expected metrics to be dropped while carbon is unavailable")
+	}
+	select {
+	case <-accepted:
+		t.Fatal("reconnected before the reconnect interval")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	s.lock.Lock()
+	s.lastReconnect = time.Now().Add(-graphiteReconnectInterval)
+	s.lock.Unlock()
+	if err := send(4); err != nil {
+		t.Fatal(err)
+	}
+	<-accepted
+	expectLines(t, lines, "spoon.a 4 1500000000")
+}
@@ -0,0 +1,278 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// influxMaxDatagramSize is the maximum number of bytes written in a single
+// udp packet. Lines are never split across packets.
+const influxMaxDatagramSize = 1400
+
+// InfluxSink sends metrics to InfluxDB using the line protocol over either HTTP
+// or UDP. Each metric path is split into a measurement and a field name, tags
+// are sent as Influx tags, and each point carries the timestamp of the agent
+// tick that produced it.
+type InfluxSink struct {
+	InfluxSinkSettings
+	metricRecorder
+	client   *http.Client
+	writeURL string
+	lock     sync.Mutex
+	udpConn  net.Conn
+}
+
+type InfluxSinkSettings struct {
+	Transport       string  `json:"transport"`
+	Address         string  `json:"address"`
+	Database        string  `json:"database"`
+	RetentionPolicy string  `json:"retention_policy"`
+	Precision       string  `json:"precision"`
+	Username        string  `json:"username"`
+	Password        string  `json:"password"`
+	FieldSegments   int     `json:"field_segments"`
+	Timeout         float64 `json:"timeout"`
+}
+
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+var influxMeasurementEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+var influxKeyEscaper = strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ")
+
+// formatInfluxTags renders the tags as a sorted, escaped suffix for the
+// measurement name
+func formatInfluxTags(tags Tags) string {
+	output := ""
+	for _, k := range tags.sortedKeys() {
+		if tags[k] == "" {
+			continue
+		}
+		output += "," + influxKeyEscaper.Replace(k) + "=" + influxKeyEscaper.Replace(tags[k])
+	}
+	return output
+}
+
+func NewInfluxSink(cfg *conf.SpoonConfigSink) (*InfluxSink, error) {
+	s, err := parseInfluxSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &InfluxSink{
+		InfluxSinkSettings: s,
+		lock:               sync.Mutex{},
+	}
+	sink.metricRecorder = metricRecorder{record: sink.recordNow}
+
+	if s.Transport == "http" {
+		u, _ := url.Parse(s.Address)
+		q := url.Values{}
+		q.Set("db", s.Database)
+		q.Set("precision", s.Precision)
+		if s.RetentionPolicy != "" {
+			q.Set("rp", s.RetentionPolicy)
+		}
+		u.Path = strings.TrimRight(u.Path, "/") + "/write"
+		u.RawQuery = q.Encode()
+		sink.writeURL = u.String()
+		sink.client = &http.Client{Timeout: sink.timeout()}
+	}
+	return sink, nil
+}
+
+func parseInfluxSettings(cfg *conf.SpoonConfigSink) (InfluxSinkSettings, error) {
+	s := InfluxSinkSettings{
+		Transport:     "http",
+		Precision:     "s",
+		FieldSegments: 1,
+		Timeout:       5,
+	}
+	if err := json.Unmarshal(cfg.SettingsRaw, &s); err != nil {
+		return s, fmt.Errorf("failed to parse influx settings: %s", err)
+	}
+	if s.Address == "" {
+		return s, fmt.Errorf("influx sink settings missing 'address'")
+	}
+	if _, ok := influxPrecisions[s.Precision]; !ok {
+		return s, fmt.Errorf("influx sink 'precision' must be one of ns, u, ms, or s")
+	}
+	if s.FieldSegments < 1 {
+		return s, fmt.Errorf("influx sink 'field_segments' must be >= 1")
+	}
+	if s.Timeout <= 0 {
+		return s, fmt.Errorf("influx sink 'timeout' must be > 0")
+	}
+
+	switch s.Transport {
+	case "http":
+		if s.Database == "" {
+			return s, fmt.Errorf("influx sink settings missing 'database'")
+		}
+		u, err := url.Parse(s.Address)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return s, fmt.Errorf("influx sink 'address' must be a url like http://host:8086")
+		}
+	case "udp":
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			return s, fmt.Errorf("influx sink 'address' is invalid: %s", err)
+		}
+	default:
+		return s, fmt.Errorf("influx sink 'transport' must be http or udp")
+	}
+	return s, nil
+}
+
+// recordNow sends a single metric to InfluxDB immediately
+func (s *InfluxSink) recordNow(m Metric) {
+	m.Timestamp = time.Now().UnixNano()
+	if err := s.send([]Metric{m}); err != nil {
+		log.Printf("Influx sink error: %s", err)
+	}
+}
+
+// NewBatch returns a batch of points that will all be written with the given
+// timestamp
+func (s *InfluxSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, s.send)
+}
+
+// Close closes the udp connection if there is one
+func (s *InfluxSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.udpConn == nil {
+		return nil
+	}
+	err := s.udpConn.Close()
+	s.udpConn = nil
+	return err
+}
+
+func (s *InfluxSink) timeout() time.Duration {
+	return time.Duration(s.Timeout * float64(time.Second))
+}
+
+// splitPath splits the dotted path into a measurement and field name, using
+// the last FieldSegments segments as the field.
+func (s *InfluxSink) splitPath(path string) (string, string) {
+	parts := strings.Split(path, ".")
+	split := len(parts) - s.FieldSegments
+	if split < 1 {
+		split = 1
+	}
+	if split >= len(parts) {
+		return path, "value"
+	}
+	return strings.Join(parts[:split], "."), strings.Join(parts[split:], ".")
+}
+
+// formatLines converts the metrics into line protocol. Metrics sharing a
+// measurement, tag set, and timestamp are combined into a single point.
+func (s *InfluxSink) formatLines(metrics []Metric) [][]byte {
+	type point struct {
+		measurement string
+		tags        string
+		timestamp   int64
+	}
+	order := []point{}
+	fields := map[point][]string{}
+	for _, m := range metrics {
+		if math.IsNaN(m.Value) || math.IsInf(m.Value, 0) {
+			continue
+		}
+		measurement, field := s.splitPath(StripPath(m.Path))
+		p := point{measurement: measurement, tags: formatInfluxTags(m.Tags), timestamp: m.Timestamp}
+		if _, ok := fields[p]; !ok {
+			order = append(order, p)
+		}
+		fields[p] = append(fields[p], influxKeyEscaper.Replace(field)+"="+strconv.FormatFloat(m.Value, 'f', -1, 64))
+	}
+
+	precision := int64(influxPrecisions[s.Precision])
+	lines := make([][]byte, len(order))
+	for i, p := range order {
+		lines[i] = []byte(fmt.Sprintf(
+			"%s%s %s %d\n",
+			influxMeasurementEscaper.Replace(p.measurement), p.tags, strings.Join(fields[p], ","), p.timestamp/precision,
+		))
+	}
+	return lines
+}
+
+func (s *InfluxSink) send(metrics []Metric) error {
+	lines := s.formatLines(collapseSets(metrics))
+	if len(lines) == 0 {
+		return nil
+	}
+	if s.Transport == "udp" {
+		return s.sendUDP(lines)
+	}
+	return s.sendHTTP(lines)
+}
+
+func (s *InfluxSink) sendHTTP(lines [][]byte) error {
+	req, err := http.NewRequest("POST", s.writeURL, bytes.NewReader(bytes.Join(lines, nil)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to write %d points to influx: %s", len(lines), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influx write returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *InfluxSink) sendUDP(lines [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.udpConn == nil {
+		conn, err := net.DialTimeout("udp", s.Address, s.timeout())
+		if err != nil {
+			return fmt.Errorf("failed to setup udp connection to influx at %s: %s", s.Address, err)
+		}
+		s.udpConn = conn
+	}
+
+	buf := bytes.Buffer{}
+	for i, line := range lines {
+		buf.Write(line)
+		if i == len(lines)-1 || buf.Len()+len(lines[i+1]) > influxMaxDatagramSize {
+			if _, err := s.udpConn.Write(buf.Bytes()); err != nil {
+				s.udpConn.Close()
+				s.udpConn = nil
+				return fmt.Errorf("failed to write points to influx: %s", err)
+			}
+			buf.Reset()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package sink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// influxTestServer records the body of each write request and replies with
+// the given status
+func influxTestServer(t *testing.T, status int) (*httptest.Server, *[]string, *[]*http.Request) {
+	bodies := []string{}
+	requests := []*http.Request{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request body: %s", err)
+		}
+		bodies = append(bodies, string(body))
+		requests = append(requests, r)
+		w.WriteHeader(status)
+		if status/100 != 2 {
+			w.Write([]byte(`{"error":"database not found"}`))
+		}
+	}))
+	return server, &bodies, &requests
+}
+
+func newTestInfluxSink(t *testing.T, settings map[string]interface{}) *InfluxSink {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &conf.SpoonConfigSink{}
+	cfg.Type = "influx"
+	cfg.SettingsRaw = raw
+	s, err := NewInfluxSink(cfg)
+	if err != nil {
+		t.Fatalf("failed to build sink: %s", err)
+	}
+	return s
+}
+
+func TestInfluxSinkWritesLineProtocol(t *testing.T) {
+	server, bodies, requests := influxTestServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	s := newTestInfluxSink(t, map[string]interface{}{
+		"address":  server.URL,
+		"database": "metrics",
+		"username": "user",
+		"password": "pass",
+	})
+	b := s.NewBatch(time.Unix(1500000000, 0))
+	b.Gauge("base.{cpu}.cpu_percent", 5, Tags{"cpu": "0"})
+	b.Gauge("base.mem.used", 10)
+	b.Gauge("base.mem.free", 20)
+	if err := b.Flush(); err != nil {
+		t.Fatalf("flush failed: %s", err)
+	}
+
+	if len(*bodies) != 1 {
+		t.Fatalf("expected 1 write, got %d", len(*bodies))
+	}
+	expected := "base,cpu=0 cpu_percent=5 1500000000\nbase.mem used=10,free=20 1500000000\n"
+	if (*bodies)[0] != expected {
+		t.Errorf("expected body %q, got %q", expected, (*bodies)[0])
+	}
+
+	r := (*requests)[0]
+	if r.URL.Path != "/write" || r.URL.Query().Get("db") != "metrics" || r.URL.Query().Get("precision") != "s" {
+		t.Errorf("unexpected write url %s", r.URL)
+	}
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("expected basic auth user:pass, got %s:%s", user, pass)
+	}
+}
+
+func TestInfluxSinkFieldSegments(t *testing.T) {
+	server, bodies, _ := influxTestServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	s := newTestInfluxSink(t, map[string]interface{}{
+		"address":        server.URL,
+		"database":       "metrics",
+		"field_segments": 2,
+		"precision":      "ms",
+	})
+	b := s.NewBatch(time.Unix(1500000000, 0))
+	b.Gauge("host.net.eth0.rx_bytes", 1)
+	b.Gauge("host.net.eth0.tx_bytes", 2)
+	b.Gauge("single", 3)
+	if err := b.Flush(); err != nil {
+		t.Fatalf("flush failed: %s", err)
+	}
+
+	expected := "host.net eth0.rx_bytes=1,eth0.tx_bytes=2 1500000000000\nsingle value=3 1500000000000\n"
+	if (*bodies)[0] != expected {
+		t.Errorf("expected body %q, got %q", expected, (*bodies)[0])
+	}
+}
+
+func TestInfluxSinkEscapesTags(t *testing.T) {
+	server, bodies, _ := influxTestServer(t, http.StatusNoContent)
+	defer server.Close()
+
+	s := newTestInfluxSink(t, map[string]interface{}{
+		"address":  server.URL,
+		"database": "metrics",
+	})
+	b := s.NewBatch(time.Unix(1500000000, 0))
+	b.Gauge("disk.{device}.used_bytes", 7, Tags{"device": "my disk,1=a", "empty": ""})
+	if err := b.Flush(); err != nil {
+		t.Fatalf("flush failed: %s", err)
+	}
+
+	expected := "disk,device=my\\ disk\\,1\\=a used_bytes=7 1500000000\n"
+	if (*bodies)[0] != expected {
+		t.Errorf("expected body %q, got %q", expected, (*bodies)[0])
+	}
+}
+
+func TestInfluxSinkNon2xxResponse(t *testing.T) {
+	server, _, _ := influxTestServer(t, http.StatusNotFound)
+	defer server.Close()
+
+	s := newTestInfluxSink(t, map[string]interface{}{
+		"address":  server.URL,
+		"database": "missing",
+	})
+	b := s.NewBatch(time.Unix(1500000000, 0))
+	b.Gauge("a.b", 1)
+	err := b.Flush()
+	if err == nil {
+		t.Fatal("expected an error for a 404 response")
+	}
+	if !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("expected the error to include the status and body, got %q", err)
+	}
+}
@@ -18,10 +18,48 @@ func NewLoggingSink() *LoggingSink {
 	return &LoggingSink{lock: sync.Mutex{}}
 }
 
-// Gauge writes a path/value pair to the log
-func (s *LoggingSink) Gauge(path string, value interface{}) {
+// logf writes the message to the log with the tags folded into the path and
+// listed after it
+func (s *LoggingSink) logf(format string, path string, value interface{}, tags []Tags) {
+	merged := mergeTags(tags...)
+
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	log.Printf("Value for '%v' = %v", path, value)
+	log.Printf(format, ExpandPath(path, merged)+formatTagsKey(merged), value)
+}
+
+// Gauge writes a path/value pair to the log
+func (s *LoggingSink) Gauge(path string, value interface{}, tags ...Tags) {
+	s.logf("Value for '%v' = %v", path, value, tags)
+}
+
+// Count writes a path/value pair to the log
+func (s *LoggingSink) Count(path string, n interface{}, tags ...Tags) {
+	s.logf("Count for '%v' += %v", path, n, tags)
+}
+
+// Increment writes a path to the log
+func (s *LoggingSink) Increment(path string, tags ...Tags) {
+	s.Count(path, 1, tags...)
+}
+
+// Timing writes a path/value pair to the log
+func (s *LoggingSink) Timing(path string, value interface{}, tags ...Tags) {
+	s.logf("Timing for '%v' = %v", path, value, tags)
+}
+
+// Set writes a path/member pair to the log
+func (s *LoggingSink) Set(path string, member string, tags ...Tags) {
+	s.logf("Set member for '%v' = %v", path, member, tags)
+}
+
+// Histogram writes a path/value pair to the log
+func (s *LoggingSink) Histogram(path string, value interface{}, tags ...Tags) {
+	s.logf("Histogram value for '%v' = %v", path, value, tags)
+}
+
+// Close does nothing since the log is not buffered
+func (s *LoggingSink) Close() error {
+	return nil
 }
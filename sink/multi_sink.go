@@ -0,0 +1,220 @@
+package sink
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// multiSinkQueueSize is the number of batches that can be waiting for each
+// destination before new batches are dropped.
+const multiSinkQueueSize = 100
+
+// MultiSink fans metrics out to a number of destination sinks. Each
+// destination has its own include and exclude path rules and its own queue so
+// that a slow or failing destination does not block the others.
+type MultiSink struct {
+	metricRecorder
+	destinations []*multiSinkDestination
+	lock         sync.RWMutex
+	closed       bool
+	running      sync.WaitGroup
+}
+
+type multiSinkDestination struct {
+	name    string
+	sink    Sink
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	queue   chan []Metric
+	dropped int64
+}
+
+// NewMultiSink builds each of the configured sinks, wraps them in a single
+// MultiSink, and starts delivering to them. If any sink fails to build, the
+// ones already built are closed.
+func NewMultiSink(cfgs []conf.SpoonConfigSink) (*MultiSink, error) {
+	destinations := make([]*multiSinkDestination, 0, len(cfgs))
+	fail := func(err error) (*MultiSink, error) {
+		for _, d := range destinations {
+			d.sink.Close()
+		}
+		return nil, err
+	}
+	for i, c := range cfgs {
+		name := fmt.Sprintf("%s[%d]", c.Type, i)
+		include, err := compilePathRules(c.Include)
+		if err != nil {
+			return fail(fmt.Errorf("sink %s has an invalid include rule: %s", name, err))
+		}
+		exclude, err := compilePathRules(c.Exclude)
+		if err != nil {
+			return fail(fmt.Errorf("sink %s has an invalid exclude rule: %s", name, err))
+		}
+		s, err := BuildSink(&c)
+		if err != nil {
+			return fail(fmt.Errorf("sink %s: %s", name, err))
+		}
+		destinations = append(destinations, &multiSinkDestination{
+			name:    name,
+			sink:    s.(Sink),
+			include: include,
+			exclude: exclude,
+			queue:   make(chan []Metric, multiSinkQueueSize),
+		})
+	}
+	s := &MultiSink{destinations: destinations}
+	s.metricRecorder = metricRecorder{record: s.recordNow}
+	s.start()
+	return s, nil
+}
+
+// ValidateMultiSink checks the config of each of the sinks, and their include
+// and exclude rules, without building them.
+func ValidateMultiSink(cfgs []conf.SpoonConfigSink) error {
+	for i, c := range cfgs {
+		name := fmt.Sprintf("%s[%d]", c.Type, i)
+		if err := ValidateSink(&c); err != nil {
+			return fmt.Errorf("sink %s: %s", name, err)
+		}
+		if _, err := compilePathRules(c.Include); err != nil {
+			return fmt.Errorf("sink %s has an invalid include rule: %s", name, err)
+		}
+		if _, err := compilePathRules(c.Exclude); err != nil {
+			return fmt.Errorf("sink %s has an invalid exclude rule: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func compilePathRules(rules []string) ([]*regexp.Regexp, error) {
+	output := make([]*regexp.Regexp, len(rules))
+	for i, r := range rules {
+		re, err := regexp.Compile(r)
+		if err != nil {
+			return nil, err
+		}
+		output[i] = re
+	}
+	return output, nil
+}
+
+// recordNow routes a single metric to the matching destinations
+func (s *MultiSink) recordNow(m Metric) {
+	m.Timestamp = time.Now().UnixNano()
+	s.route([]Metric{m})
+}
+
+// NewBatch returns a batch which is routed to the matching destinations when
+// flushed. Delivery happens in the background so Flush does not block.
+func (s *MultiSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, s.route)
+}
+
+func (s *MultiSink) route(metrics []Metric) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.closed {
+		return fmt.Errorf("dropped %d metrics because the sink is closed", len(metrics))
+	}
+
+	for _, d := range s.destinations {
+		matched := make([]Metric, 0, len(metrics))
+		for _, m := range metrics {
+			if d.matches(ExpandPath(m.Path, m.Tags)) {
+				matched = append(matched, m)
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		select {
+		case d.queue <- matched:
+		default:
+			total := atomic.AddInt64(&d.dropped, int64(len(matched)))
+			log.Printf("Sink %s is not keeping up, dropped %d metrics (%d total)", d.name, len(matched), total)
+		}
+	}
+	return nil
+}
+
+// start launches the delivery goroutine for each destination
+func (s *MultiSink) start() {
+	for _, d := range s.destinations {
+		s.running.Add(1)
+		go func(d *multiSinkDestination) {
+			defer s.running.Done()
+			d.run()
+		}(d)
+	}
+}
+
+// Close waits for each destination to deliver its queued metrics and then
+// closes it
+func (s *MultiSink) Close() error {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		for _, d := range s.destinations {
+			close(d.queue)
+		}
+	}
+	s.lock.Unlock()
+	s.running.Wait()
+
+	var firstErr error
+	for _, d := range s.destinations {
+		if err := d.sink.Close(); err != nil {
+			log.Printf("Failed to close sink %s: %s", d.name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (d *multiSinkDestination) matches(path string) bool {
+	if len(d.include) > 0 {
+		included := false
+		for _, re := range d.include {
+			if re.MatchString(path) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, re := range d.exclude {
+		if re.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+func (d *multiSinkDestination) run() {
+	for metrics := range d.queue {
+		bs, ok := d.sink.(BatchSink)
+		if !ok {
+			for _, m := range metrics {
+				replayMetric(d.sink, m)
+			}
+			continue
+		}
+		b := bs.NewBatch(time.Unix(0, metrics[0].Timestamp))
+		for _, m := range metrics {
+			replayMetric(b, m)
+		}
+		if err := b.Flush(); err != nil {
+			log.Printf("Sink %s error: %s", d.name, err)
+		}
+	}
+}
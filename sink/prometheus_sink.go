@@ -0,0 +1,346 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// PrometheusSink keeps the most recent value for each path and serves them
+// over HTTP in the Prometheus text exposition format. Tags become labels.
+// Counts are accumulated into counters, sets are exposed as the number of
+// members seen within the staleness period, and all other types are exposed
+// as gauges.
+type PrometheusSink struct {
+	PrometheusSinkSettings
+	metricRecorder
+	labelRules []*regexp.Regexp
+	lock       sync.Mutex
+	series     map[string]*prometheusSeries
+	// kinds holds the type of each metric name, since a name can only be
+	// exposed as one type. conflicts holds the names that have been rejected.
+	kinds     map[string]string
+	conflicts map[string]bool
+	listener  net.Listener
+	server    *http.Server
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type PrometheusSinkSettings struct {
+	ListenAddress string                    `json:"listen_address"`
+	StaleAfter    float64                   `json:"stale_after"`
+	LabelRules    []PrometheusSinkLabelRule `json:"label_rules"`
+}
+
+// PrometheusSinkLabelRule converts segments of a metric path into labels. Each
+// named capture group in the regex becomes a label and the captured text is
+// removed from the metric name.
+type PrometheusSinkLabelRule struct {
+	Match string `json:"match"`
+}
+
+type prometheusSeries struct {
+	name    string
+	labels  string
+	kind    string
+	value   float64
+	members map[string]time.Time
+	updated time.Time
+}
+
+var invalidPrometheusNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func NewPrometheusSink(cfg *conf.SpoonConfigSink) (*PrometheusSink, error) {
+	s, rules, err := parsePrometheusSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", s.ListenAddress)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus sink failed to listen on %s: %s", s.ListenAddress, err)
+	}
+
+	sink := &PrometheusSink{
+		PrometheusSinkSettings: s,
+		labelRules:             rules,
+		lock:                   sync.Mutex{},
+		series:                 make(map[string]*prometheusSeries),
+		kinds:                  make(map[string]string),
+		conflicts:              make(map[string]bool),
+		listener:               listener,
+		stop:                   make(chan struct{}),
+	}
+	sink.metricRecorder = metricRecorder{record: sink.record}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", sink.handleMetrics)
+	sink.server = &http.Server{Handler: mux}
+	log.Printf("Serving prometheus metrics on %s/metrics", listener.Addr())
+	go func() {
+		if err := sink.server.Serve(listener); err != http.ErrServerClosed {
+			log.Printf("Prometheus sink stopped serving on %s: %s", s.ListenAddress, err)
+		}
+	}()
+	go sink.pruneLoop()
+	return sink, nil
+}
+
+// prometheusMaxPruneInterval is the longest time between removing stale series
+const prometheusMaxPruneInterval = time.Minute
+
+// parsePrometheusSettings returns the settings and the compiled label rules
+func parsePrometheusSettings(cfg *conf.SpoonConfigSink) (PrometheusSinkSettings, []*regexp.Regexp, error) {
+	s := PrometheusSinkSettings{
+		StaleAfter: 300,
+	}
+	if err := json.Unmarshal(cfg.SettingsRaw, &s); err != nil {
+		return s, nil, fmt.Errorf("failed to parse prometheus settings: %s", err)
+	}
+	if s.ListenAddress == "" {
+		return s, nil, fmt.Errorf("prometheus sink settings missing 'listen_address'")
+	}
+	if _, _, err := net.SplitHostPort(s.ListenAddress); err != nil {
+		return s, nil, fmt.Errorf("prometheus sink 'listen_address' is invalid: %s", err)
+	}
+	if s.StaleAfter <= 0 {
+		return s, nil, fmt.Errorf("prometheus sink 'stale_after' must be > 0")
+	}
+
+	rules := make([]*regexp.Regexp, len(s.LabelRules))
+	for i, r := range s.LabelRules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return s, nil, fmt.Errorf("prometheus sink label rule %d is invalid: %s", i, err)
+		}
+		rules[i] = re
+	}
+	return s, rules, nil
+}
+
+// record updates the series for the metric path. A metric whose name is
+// already exposed as a different type is dropped, since the exposition format
+// only allows one type per name.
+func (s *PrometheusSink) record(m Metric) {
+	name, labels := s.mapPath(StripPath(m.Path), m.Tags)
+	key := name + labels
+	kind := "gauge"
+	if m.Type == CountType {
+		kind = "counter"
+	}
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if existing, ok := s.kinds[name]; ok && existing != kind {
+		if !s.conflicts[name] {
+			s.conflicts[name] = true
+			log.Printf("Prometheus sink dropping %s metric %s because it is already a %s", kind, name, existing)
+		}
+		return
+	}
+	s.kinds[name] = kind
+	ser, ok := s.series[key]
+	if !ok {
+		ser = &prometheusSeries{name: name, labels: labels, kind: kind, members: map[string]time.Time{}}
+		s.series[key] = ser
+	}
+	switch m.Type {
+	case CountType:
+		ser.value += m.Value
+	case SetType:
+		ser.members[m.Member] = now
+	default:
+		ser.value = m.Value
+	}
+	ser.updated = now
+}
+
+// Close stops the http server. The listener is closed here as well, since the
+// server may not have started serving on it yet, so that the address is free
+// as soon as Close returns.
+func (s *PrometheusSink) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	err := s.server.Close()
+	s.listener.Close()
+	return err
+}
+
+// pruneLoop removes stale series until the sink is closed. This is done in
+// the background rather than while serving a scrape, so that scrapes don't
+// change what is stored.
+func (s *PrometheusSink) pruneLoop() {
+	interval := time.Duration(s.StaleAfter * float64(time.Second))
+	if interval > prometheusMaxPruneInterval {
+		interval = prometheusMaxPruneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.prune(now)
+		}
+	}
+}
+
+// prune removes series and set members that have not been updated within the
+// staleness period. Once every series with a name has been removed, the name
+// may be used with a different type.
+func (s *PrometheusSink) prune(now time.Time) {
+	staleBefore := now.Add(-time.Duration(s.StaleAfter * float64(time.Second)))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.kinds = make(map[string]string)
+	for k, ser := range s.series {
+		if ser.updated.Before(staleBefore) {
+			delete(s.series, k)
+			continue
+		}
+		for member, seen := range ser.members {
+			if seen.Before(staleBefore) {
+				delete(ser.members, member)
+			}
+		}
+		s.kinds[ser.name] = ser.kind
+	}
+}
+
+func (s *PrometheusSink) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(s.render(time.Now()))
+}
+
+// render formats the series that are not stale in the text exposition format,
+// sorted by name and labels. Sets are counted from their members seen within
+// the staleness period.
+func (s *PrometheusSink) render(now time.Time) []byte {
+	staleBefore := now.Add(-time.Duration(s.StaleAfter * float64(time.Second)))
+
+	s.lock.Lock()
+	current := make([]prometheusSeries, 0, len(s.series))
+	for _, ser := range s.series {
+		if ser.updated.Before(staleBefore) {
+			continue
+		}
+		rendered := *ser
+		if len(ser.members) > 0 {
+			rendered.value = 0
+			for _, seen := range ser.members {
+				if !seen.Before(staleBefore) {
+					rendered.value++
+				}
+			}
+		}
+		current = append(current, rendered)
+	}
+	s.lock.Unlock()
+
+	sort.Slice(current, func(i, j int) bool {
+		if current[i].name != current[j].name {
+			return current[i].name < current[j].name
+		}
+		return current[i].labels < current[j].labels
+	})
+
+	buf := bytes.Buffer{}
+	lastName := ""
+	for _, ser := range current {
+		if ser.name != lastName {
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", ser.name, ser.kind)
+			lastName = ser.name
+		}
+		buf.WriteString(ser.name)
+		buf.WriteString(ser.labels)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(ser.value, 'g', -1, 64))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// mapPath converts a dotted Spoon path and its tags into a metric name and
+// rendered label set. Labels from the first label rule that matches take
+// precedence over tags of the same name.
+func (s *PrometheusSink) mapPath(path string, tags Tags) (string, string) {
+	labels := map[string]string{}
+	for k, v := range tags {
+		labels[sanitisePrometheusName(k)] = v
+	}
+	for _, re := range s.labelRules {
+		idx := re.FindStringSubmatchIndex(path)
+		if idx == nil {
+			continue
+		}
+		remaining := []byte(path)
+		// remove captured text from the end backwards so that the earlier
+		// indexes remain valid
+		for g := len(re.SubexpNames()) - 1; g > 0; g-- {
+			gname := re.SubexpNames()[g]
+			start, end := idx[2*g], idx[2*g+1]
+			if gname == "" || start < 0 {
+				continue
+			}
+			labels[sanitisePrometheusName(gname)] = path[start:end]
+			remaining = append(remaining[:start], remaining[end:]...)
+		}
+		path = string(remaining)
+		break
+	}
+	return prometheusName(path), formatPrometheusLabels(labels)
+}
+
+// prometheusName converts a dotted path into a valid metric name. Empty path
+// segments are dropped, dots become underscores and any other invalid
+// characters are replaced.
+func prometheusName(path string) string {
+	parts := strings.Split(path, ".")
+	kept := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return sanitisePrometheusName(strings.Join(kept, "_"))
+}
+
+func sanitisePrometheusName(name string) string {
+	name = invalidPrometheusNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=\"" + prometheusLabelEscaper.Replace(labels[k]) + "\""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
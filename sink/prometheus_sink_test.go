@@ -0,0 +1,209 @@
+package sink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+func prometheusTestConfig(t *testing.T, settings map[string]interface{}) *conf.SpoonConfigSink {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &conf.SpoonConfigSink{}
+	cfg.Type = "prometheus"
+	cfg.SettingsRaw = raw
+	return cfg
+}
+
+// freeAddress returns a local address that nothing is listening on
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestPrometheusSinkListenFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, err := NewPrometheusSink(prometheusTestConfig(t, map[string]interface{}{
+		"listen_address": l.Addr().String(),
+	})); err == nil {
+		t.Error("expected an error when the address is in use")
+	}
+}
+
+func TestPrometheusSinkCloseReleasesAddress(t *testing.T) {
+	cfg := prometheusTestConfig(t, map[string]interface{}{
+		"listen_address": freeAddress(t),
+	})
+	s, err := NewPrometheusSink(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewPrometheusSink(cfg)
+	if err != nil {
+		t.Fatalf("failed to rebuild the sink on the same address: %s", err)
+	}
+	s.Close()
+}
+
+func TestPrometheusSinkRejectsConflictingKinds(t *testing.T) {
+	s, err := NewPrometheusSink(prometheusTestConfig(t, map[string]interface{}{
+		"listen_address": "127.0.0.1:0",
+		"stale_after":    60,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Gauge("a.b", 1, Tags{"x": "1"})
+	s.Count("a.b", 2, Tags{"x": "2"})
+	s.Count("a.c", 2)
+	s.Gauge("a.c", 5)
+
+	expected := "# TYPE a_b gauge\n" +
+		"a_b{x=\"1\"} 1\n" +
+		"# TYPE a_c counter\n" +
+		"a_c 2\n"
+	if out := string(s.render(time.Now())); out != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+
+	// once the series are stale the name can change type
+	s.prune(time.Now().Add(2 * time.Minute))
+	s.Count("a.b", 3)
+	if out := string(s.render(time.Now())); !strings.Contains(out, "# TYPE a_b counter\na_b 3\n") {
+		t.Errorf("expected a_b to be a counter, got:\n%s", out)
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	cases := map[string]string{
+		"base.cpu.cpu_percent": "base_cpu_cpu_percent",
+		"base..disk.":          "base_disk",
+		"web-1.requests/sec":   "web_1_requests_sec",
+		"0.load":               "_0_load",
+		"ok:name":              "ok:name",
+	}
+	for path, expected := range cases {
+		if name := prometheusName(path); name != expected {
+			t.Errorf("expected %s to become %s, got %s", path, expected, name)
+		}
+	}
+	if name := sanitisePrometheusName(""); name != "_" {
+		t.Errorf("expected an empty name to become _, got %s", name)
+	}
+}
+
+func TestPrometheusSinkMapPath(t *testing.T) {
+	s, err := NewPrometheusSink(prometheusTestConfig(t, map[string]interface{}{
+		"listen_address": "127.0.0.1:0",
+		"label_rules": []map[string]string{
+			{"match": `\.queue\.(?P<queue>[^.]+)\.`},
+			{"match": `^(?P<host>[^.]+)\.app\.(?P<app>[^.]+)\.`},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	cases := []struct {
+		path   string
+		tags   Tags
+		name   string
+		labels string
+	}{
+		{"base.load.load1", nil, "base_load_load1", ""},
+		{"base.cpu.cpu_percent", Tags{"cpu": "0"}, "base_cpu_cpu_percent", `{cpu="0"}`},
+		{"base.app.queue.emails.length", nil, "base_app_queue_length", `{queue="emails"}`},
+		// only the first matching rule is applied, and it wins over tags
+		{"web1.app.shop.queue.orders.length", Tags{"queue": "x"}, "web1_app_shop_queue_length", `{queue="orders"}`},
+		{"web1.app.shop.requests", Tags{"role": "a\"b"}, "app_requests", `{app="shop",host="web1",role="a\"b"}`},
+	}
+	for _, c := range cases {
+		name, labels := s.mapPath(c.path, c.tags)
+		if name != c.name || labels != c.labels {
+			t.Errorf("expected %s to map to %s%s, got %s%s", c.path, c.name, c.labels, name, labels)
+		}
+	}
+}
+
+func TestPrometheusSinkServesMetrics(t *testing.T) {
+	s, err := NewPrometheusSink(prometheusTestConfig(t, map[string]interface{}{
+		"listen_address": "127.0.0.1:0",
+		"stale_after":    60,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Gauge("base.cpu.{cpu}.cpu_percent", 5, Tags{"cpu": "0"})
+	s.Count("base.requests", 2)
+	s.Count("base.requests", 3)
+	s.Set("base.users", "alice")
+	s.Set("base.users", "bob")
+	s.Gauge("base.old", 1)
+	s.lock.Lock()
+	s.series["base_old"].updated = time.Now().Add(-2 * time.Minute)
+	s.lock.Unlock()
+
+	server := httptest.NewServer(http.HandlerFunc(s.handleMetrics))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "# TYPE base_cpu_cpu_percent gauge\n" +
+		"base_cpu_cpu_percent{cpu=\"0\"} 5\n" +
+		"# TYPE base_requests counter\n" +
+		"base_requests 5\n" +
+		"# TYPE base_users gauge\n" +
+		"base_users 2\n"
+	if string(body) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected a text/plain content type, got %s", ct)
+	}
+
+	// the stale series is only removed by pruning, not by the scrape
+	s.lock.Lock()
+	_, kept := s.series["base_old"]
+	s.lock.Unlock()
+	if !kept {
+		t.Error("expected the scrape to leave the stale series in place")
+	}
+	s.prune(time.Now())
+	if _, ok := s.series["base_old"]; ok {
+		t.Error("expected the stale series to be pruned")
+	}
+}
@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"log"
+)
+
+// metricRecorder implements Sink by converting each call into a Metric and
+// passing it to a single record function. It is embedded by sinks which treat
+// the different metric types in a similar way.
+type metricRecorder struct {
+	record func(Metric)
+}
+
+func (r metricRecorder) recordValue(t MetricType, path string, value interface{}, tags []Tags) {
+	v, err := toFloat64(value)
+	if err != nil {
+		log.Printf("Dropping metric '%s': %s", path, err)
+		return
+	}
+	r.record(Metric{Path: path, Type: t, Value: v, Tags: mergeTags(tags...)})
+}
+
+// Gauge records a path/value pair as a gauge
+func (r metricRecorder) Gauge(path string, value interface{}, tags ...Tags) {
+	r.recordValue(GaugeType, path, value, tags)
+}
+
+// Count records a path/value pair as a counter increment
+func (r metricRecorder) Count(path string, n interface{}, tags ...Tags) {
+	r.recordValue(CountType, path, n, tags)
+}
+
+// Increment records a counter increment of one
+func (r metricRecorder) Increment(path string, tags ...Tags) {
+	r.record(Metric{Path: path, Type: CountType, Value: 1, Tags: mergeTags(tags...)})
+}
+
+// Timing records a path/value pair as a timing
+func (r metricRecorder) Timing(path string, value interface{}, tags ...Tags) {
+	r.recordValue(TimingType, path, value, tags)
+}
+
+// Set records a member of the set at the path
+func (r metricRecorder) Set(path string, member string, tags ...Tags) {
+	r.record(Metric{Path: path, Type: SetType, Value: 1, Member: member, Tags: mergeTags(tags...)})
+}
+
+// Histogram records a path/value pair as a histogram sample
+func (r metricRecorder) Histogram(path string, value interface{}, tags ...Tags) {
+	r.recordValue(HistogramType, path, value, tags)
+}
+
+// replayMetric sends a recorded metric to the sink using the method that
+// matches its type.
+func replayMetric(s Sink, m Metric) {
+	switch m.Type {
+	case CountType:
+		s.Count(m.Path, m.Value, m.Tags)
+	case TimingType:
+		s.Timing(m.Path, m.Value, m.Tags)
+	case SetType:
+		s.Set(m.Path, m.Member, m.Tags)
+	case HistogramType:
+		s.Histogram(m.Path, m.Value, m.Tags)
+	default:
+		s.Gauge(m.Path, m.Value, m.Tags)
+	}
+}
+
+// collapseSets replaces the members recorded for each set with a single gauge
+// counting the unique members. This is used by sinks that have no native
+// concept of a set.
+func collapseSets(metrics []Metric) []Metric {
+	output := make([]Metric, 0, len(metrics))
+	members := map[string]map[string]bool{}
+	positions := map[string]int{}
+	for _, m := range metrics {
+		if m.Type != SetType {
+			output = append(output, m)
+			continue
+		}
+		key := ExpandPath(m.Path, m.Tags) + formatTagsKey(m.Tags)
+		if _, ok := members[key]; !ok {
+			members[key] = map[string]bool{}
+			positions[key] = len(output)
+			output = append(output, Metric{Path: m.Path, Type: GaugeType, Tags: m.Tags, Timestamp: m.Timestamp})
+		}
+		members[key][m.Member] = true
+	}
+	for key, set := range members {
+		output[positions[key]].Value = float64(len(set))
+	}
+	return output
+}
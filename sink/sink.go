@@ -2,29 +2,159 @@ package sink
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/AstromechZA/spoon/conf"
 )
 
+// MetricType identifies which Sink method a Metric was recorded with.
+type MetricType int
+
+const (
+	GaugeType MetricType = iota
+	CountType
+	TimingType
+	SetType
+	HistogramType
+)
+
 type Metric struct {
 	Path      string
+	Type      MetricType
 	Value     float64
+	Member    string
+	Tags      Tags
 	Timestamp int64
 }
 
 // A Sink is an object that acts as the destination for results from the
-// agents.
+// agents. Every method accepts optional Tags which are merged together.
 type Sink interface {
-	Gauge(bucket string, value interface{})
+	// Gauge records the current value of something
+	Gauge(bucket string, value interface{}, tags ...Tags)
+
+	// Count adds n to a counter
+	Count(bucket string, n interface{}, tags ...Tags)
+
+	// Increment adds one to a counter
+	Increment(bucket string, tags ...Tags)
+
+	// Timing records a duration. The unit should be clear from the bucket
+	// name, like 'elapsed_seconds'.
+	Timing(bucket string, value interface{}, tags ...Tags)
+
+	// Set records a member of a set so that the number of unique members
+	// can be counted
+	Set(bucket string, member string, tags ...Tags)
+
+	// Histogram records a value whose distribution is interesting
+	Histogram(bucket string, value interface{}, tags ...Tags)
+
+	// Close sends any buffered metrics and releases the resources held by
+	// the sink. The sink must not be used afterwards.
+	Close() error
+}
+
+// A BatchSink is a Sink that prefers to receive the metrics from a single
+// agent tick together so that they can be sent in one go.
+type BatchSink interface {
+	Sink
+
+	// NewBatch returns a new Batch for metrics produced at the given time.
+	NewBatch(timestamp time.Time) Batch
 }
 
+// A Batch collects metrics until Flush is called.
+type Batch interface {
+	Sink
+
+	// Flush sends all of the collected metrics to the parent sink.
+	Flush() error
+}
+
+// BuildSink constructs the sink described by the config, wrapping it in a
+// spool if one is configured.
 func BuildSink(cfg *conf.SpoonConfigSink) (interface{}, error) {
+	s, err := buildSinkOfType(cfg)
+	if err != nil || cfg.Spool == nil {
+		return s, err
+	}
+	return NewSpoolSink(s.(Sink), cfg.Spool)
+}
+
+// ValidateSink checks the config of a sink, and any spool, without building
+// it. Nothing is connected to, bound, or created, so it is safe to use while
+// the current sink is running.
+func ValidateSink(cfg *conf.SpoonConfigSink) error {
+	var err error
+	switch cfg.Type {
+	case "log":
+	case "statsd":
+		_, err = parseStatsdSettings(cfg)
+	case "graphite":
+		_, err = parseGraphiteSettings(cfg)
+	case "prometheus":
+		_, _, err = parsePrometheusSettings(cfg)
+	case "influx":
+		_, err = parseInfluxSettings(cfg)
+	default:
+		err = fmt.Errorf("Unrecognised sink type '%v'", cfg.Type)
+	}
+	if err != nil || cfg.Spool == nil {
+		return err
+	}
+	if cfg.Type == "log" || cfg.Type == "prometheus" {
+		return fmt.Errorf("the %s sink does not support spooling", cfg.Type)
+	}
+	_, err = parseSpoolSettings(cfg.Spool)
+	return err
+}
+
+func buildSinkOfType(cfg *conf.SpoonConfigSink) (interface{}, error) {
 	switch cfg.Type {
 	case "log":
 		return NewLoggingSink(), nil
 	case "statsd":
 		return NewStatsdSink(cfg)
+	case "graphite":
+		return NewGraphiteSink(cfg)
+	case "prometheus":
+		return NewPrometheusSink(cfg)
+	case "influx":
+		return NewInfluxSink(cfg)
 	default:
 		return nil, fmt.Errorf("Unrecognised sink type '%v'", cfg.Type)
 	}
 }
+
+// toFloat64 converts the numeric values passed to Gauge into a float64.
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	default:
+		return 0, fmt.Errorf("value %v of type %T is not numeric", value, value)
+	}
+}
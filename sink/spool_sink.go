@@ -0,0 +1,334 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// SpoolSink wraps a BatchSink and writes any batches that fail to be delivered
+// to a bounded queue of files on disk. The spooled batches are replayed in
+// order, with their original timestamps, once the destination is reachable
+// again.
+type SpoolSink struct {
+	conf.SpoonConfigSpool
+	metricRecorder
+	inner BatchSink
+
+	// lock guards the spool state. It is never held while sending to the
+	// inner sink, so a slow destination does not hold up spooling.
+	lock    sync.Mutex
+	files   []spoolFile
+	depth   int64
+	nextSeq uint64
+	dropped int64
+
+	// sendLock is held for reading while sending to the inner sink, so that
+	// Close can wait for any sends in progress before closing it
+	sendLock  sync.RWMutex
+	startOnce sync.Once
+	closeOnce sync.Once
+	closeErr  error
+	stop      chan struct{}
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// spoolBatch is the on-disk format of a spooled batch
+type spoolBatch struct {
+	Timestamp int64    `json:"timestamp"`
+	Metrics   []Metric `json:"metrics"`
+}
+
+const spoolFileSuffix = ".batch.json"
+
+// NewSpoolSink wraps the given sink in a spool. The sink must support
+// batching so that delivery failures can be detected. The log sink cannot fail
+// and the prometheus sink is scraped rather than delivering anything, so
+// neither of them can be spooled.
+func NewSpoolSink(inner Sink, cfg *conf.SpoonConfigSpool) (*SpoolSink, error) {
+	bs, ok := inner.(BatchSink)
+	if !ok {
+		return nil, fmt.Errorf("spool requires a sink that delivers metrics and reports failures, %T does not", inner)
+	}
+	settings, err := parseSpoolSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(settings.Directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %s", err)
+	}
+
+	s := &SpoolSink{
+		SpoonConfigSpool: settings,
+		inner:            bs,
+		lock:             sync.Mutex{},
+		stop:             make(chan struct{}),
+	}
+	s.metricRecorder = metricRecorder{record: s.recordNow}
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %s", err)
+	}
+	return s, nil
+}
+
+// parseSpoolSettings fills in the defaults for the spool settings
+func parseSpoolSettings(cfg *conf.SpoonConfigSpool) (conf.SpoonConfigSpool, error) {
+	settings := *cfg
+	if settings.Directory == "" {
+		return settings, fmt.Errorf("spool settings missing 'directory'")
+	}
+	if settings.MaxBytes == 0 {
+		settings.MaxBytes = 100 * 1024 * 1024
+	}
+	if settings.MaxAge == 0 {
+		settings.MaxAge = 24 * 60 * 60
+	}
+	if settings.RetryInterval == 0 {
+		settings.RetryInterval = 10
+	}
+	if settings.MaxBytes < 0 || settings.MaxAge < 0 || settings.RetryInterval < 0 {
+		return settings, fmt.Errorf("spool 'max_bytes', 'max_age' and 'retry_interval' must be > 0")
+	}
+	return settings, nil
+}
+
+// load finds any batches left in the spool directory by a previous run
+func (s *SpoolSink) load() error {
+	entries, err := ioutil.ReadDir(s.Directory)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), spoolFileSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), spoolFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, spoolFile{name: e.Name(), size: e.Size()})
+		s.depth += e.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	return nil
+}
+
+// recordNow delivers a single metric immediately
+func (s *SpoolSink) recordNow(m Metric) {
+	m.Timestamp = time.Now().UnixNano()
+	s.deliver([]Metric{m})
+}
+
+// NewBatch returns a batch which is delivered or spooled when flushed
+func (s *SpoolSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, s.deliver)
+}
+
+// deliver sends the metrics to the inner sink, or appends them to the spool if
+// the spool is not empty or the delivery fails.
+func (s *SpoolSink) deliver(metrics []Metric) error {
+	s.startOnce.Do(s.start)
+
+	s.lock.Lock()
+	empty := len(s.files) == 0
+	s.lock.Unlock()
+
+	if empty {
+		err := s.send(metrics)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Spooling %d metrics after delivery failed: %s", len(metrics), err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.append(metrics)
+}
+
+// send writes the metrics to the inner sink as a single batch using their
+// original timestamp. The lock must not be held.
+func (s *SpoolSink) send(metrics []Metric) error {
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+
+	b := s.inner.NewBatch(time.Unix(0, metrics[0].Timestamp))
+	for _, m := range metrics {
+		replayMetric(b, m)
+	}
+	return b.Flush()
+}
+
+// append writes the metrics to a new spool file, dropping the oldest files if
+// the spool would grow beyond its size limit. The lock must be held.
+func (s *SpoolSink) append(metrics []Metric) error {
+	data, err := json.Marshal(spoolBatch{Timestamp: metrics[0].Timestamp, Metrics: metrics})
+	if err != nil {
+		return err
+	}
+	for len(s.files) > 0 && s.depth+int64(len(data)) > s.MaxBytes {
+		s.dropOldest("spool is full")
+	}
+	if int64(len(data)) > s.MaxBytes {
+		s.dropped += int64(len(metrics))
+		return fmt.Errorf("dropped batch of %d metrics which is larger than the spool", len(metrics))
+	}
+
+	name := fmt.Sprintf("%020d%s", s.nextSeq, spoolFileSuffix)
+	if err := ioutil.WriteFile(filepath.Join(s.Directory, name), data, 0644); err != nil {
+		s.dropped += int64(len(metrics))
+		return fmt.Errorf("failed to write spool file: %s", err)
+	}
+	s.nextSeq++
+	s.files = append(s.files, spoolFile{name: name, size: int64(len(data))})
+	s.depth += int64(len(data))
+	return nil
+}
+
+// dropOldest removes the oldest spool file. The lock must be held.
+func (s *SpoolSink) dropOldest(reason string) {
+	f := s.files[0]
+	if batch, err := s.read(f); err == nil {
+		s.dropped += int64(len(batch.Metrics))
+	}
+	log.Printf("Dropping spooled batch %s because %s", f.name, reason)
+	s.remove(f)
+}
+
+// remove deletes the oldest spool file. The lock must be held.
+func (s *SpoolSink) remove(f spoolFile) {
+	if err := os.Remove(filepath.Join(s.Directory, f.name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove spool file %s: %s", f.name, err)
+	}
+	s.files = s.files[1:]
+	s.depth -= f.size
+}
+
+func (s *SpoolSink) read(f spoolFile) (*spoolBatch, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Directory, f.name))
+	if err != nil {
+		return nil, err
+	}
+	batch := &spoolBatch{}
+	if err := json.Unmarshal(data, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// start launches the replay loop. This happens on first use so that building
+// the sink during config validation does not start replaying.
+func (s *SpoolSink) start() {
+	go func() {
+		interval := time.Duration(s.RetryInterval * float64(time.Second))
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(interval):
+			}
+			s.replay()
+			s.report()
+		}
+	}()
+}
+
+// Close stops the replay loop, waits for any sends in progress, and closes the
+// inner sink. Anything left in the spool will be replayed the next time the
+// sink is started. Closing the sink more than once has no effect.
+func (s *SpoolSink) Close() error {
+	s.closeOnce.Do(func() {
+		s.startOnce.Do(func() {})
+		close(s.stop)
+
+		s.sendLock.Lock()
+		defer s.sendLock.Unlock()
+		s.closeErr = s.inner.Close()
+	})
+	return s.closeErr
+}
+
+// replay sends spooled batches to the inner sink, oldest first, until one fails
+func (s *SpoolSink) replay() {
+	maxAge := time.Duration(s.MaxAge * float64(time.Second))
+	replayed := 0
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.lock.Lock()
+		if len(s.files) == 0 {
+			s.lock.Unlock()
+			break
+		}
+		f := s.files[0]
+		batch, err := s.read(f)
+		if err != nil {
+			log.Printf("Dropping unreadable spool file %s: %s", f.name, err)
+			s.remove(f)
+			s.lock.Unlock()
+			continue
+		}
+		if len(batch.Metrics) == 0 || time.Since(time.Unix(0, batch.Timestamp)) > maxAge {
+			s.dropOldest("it is older than max_age")
+			s.lock.Unlock()
+			continue
+		}
+		s.lock.Unlock()
+
+		if err := s.send(batch.Metrics); err != nil {
+			log.Printf("Failed to replay spooled batches, will retry: %s", err)
+			break
+		}
+
+		// the file may have been dropped to make room while it was being sent
+		s.lock.Lock()
+		if len(s.files) > 0 && s.files[0].name == f.name {
+			s.remove(f)
+		}
+		s.lock.Unlock()
+		replayed++
+	}
+	if replayed > 0 {
+		log.Printf("Replayed %d spooled batches", replayed)
+	}
+}
+
+// report logs the spool depth and drop count, and sends them as metrics if a
+// metric path is configured
+func (s *SpoolSink) report() {
+	s.lock.Lock()
+	files, depth, dropped := len(s.files), s.depth, s.dropped
+	s.lock.Unlock()
+
+	if files > 0 || dropped > 0 {
+		log.Printf("Spool %s holds %d batches (%d bytes), %d metrics dropped so far", s.Directory, files, depth, dropped)
+	}
+	if s.MetricPath != "" {
+		b := s.NewBatch(time.Now())
+		b.Gauge(s.MetricPath+".depth_batches", files)
+		b.Gauge(s.MetricPath+".depth_bytes", depth)
+		b.Gauge(s.MetricPath+".dropped_metrics", dropped)
+		b.Flush()
+	}
+}
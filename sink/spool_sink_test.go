@@ -0,0 +1,182 @@
+package sink
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AstromechZA/spoon/conf"
+)
+
+// fakeBatchSink records the batches it is sent. Sends fail while failing is
+// set, and signal on sending then wait for block to be closed if it is set.
+type fakeBatchSink struct {
+	metricRecorder
+	lock    sync.Mutex
+	failing bool
+	block   chan struct{}
+	sending chan struct{}
+	batches [][]Metric
+	closed  int
+}
+
+func newFakeBatchSink() *fakeBatchSink {
+	s := &fakeBatchSink{sending: make(chan struct{}, 10)}
+	s.metricRecorder = metricRecorder{record: func(m Metric) {}}
+	return s
+}
+
+func (s *fakeBatchSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, func(metrics []Metric) error {
+		s.lock.Lock()
+		block := s.block
+		s.lock.Unlock()
+		if block != nil {
+			s.sending <- struct{}{}
+			<-block
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.failing {
+			return errors.New("destination is down")
+		}
+		s.batches = append(s.batches, metrics)
+		return nil
+	})
+}
+
+func (s *fakeBatchSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed++
+	return nil
+}
+
+func newTestSpoolSink(t *testing.T, inner Sink) (*SpoolSink, func()) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSpoolSink(inner, &conf.SpoonConfigSpool{Directory: dir, RetryInterval: 3600})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("failed to build spool: %s", err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestSpoolSinkReplaysInOrder(t *testing.T) {
+	inner := newFakeBatchSink()
+	s, cleanup := newTestSpoolSink(t, inner)
+	defer cleanup()
+	defer s.Close()
+
+	start := time.Now().Add(-time.Minute)
+	inner.failing = true
+	for i := 1; i <= 3; i++ {
+		b := s.NewBatch(start.Add(time.Duration(i) * time.Second))
+		b.Gauge("a.b", i)
+		if err := b.Flush(); err != nil {
+			t.Fatalf("failed to spool batch: %s", err)
+		}
+	}
+	if len(s.files) != 3 {
+		t.Fatalf("expected 3 spooled batches, got %d", len(s.files))
+	}
+
+	inner.failing = false
+	s.replay()
+	if len(s.files) != 0 || s.depth != 0 {
+		t.Errorf("expected the spool to be empty, got %d files of %d bytes", len(s.files), s.depth)
+	}
+	if len(inner.batches) != 3 {
+		t.Fatalf("expected 3 replayed batches, got %d", len(inner.batches))
+	}
+	for i, batch := range inner.batches {
+		if batch[0].Value != float64(i+1) || batch[0].Timestamp != start.Add(time.Duration(i+1)*time.Second).UnixNano() {
+			t.Errorf("batch %d replayed out of order or without its timestamp: %+v", i, batch[0])
+		}
+	}
+}
+
+func TestSpoolSinkSpoolsWhileReplaySends(t *testing.T) {
+	inner := newFakeBatchSink()
+	s, cleanup := newTestSpoolSink(t, inner)
+	defer cleanup()
+	defer s.Close()
+
+	inner.failing = true
+	b := s.NewBatch(time.Now())
+	b.Gauge("a.b", 1)
+	b.Flush()
+
+	// hold the replay in the middle of sending to the inner sink
+	release := make(chan struct{})
+	inner.lock.Lock()
+	inner.failing = false
+	inner.block = release
+	inner.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.replay()
+		close(done)
+	}()
+	<-inner.sending
+
+	flushed := make(chan error)
+	go func() {
+		b := s.NewBatch(time.Now())
+		b.Gauge("a.b", 2)
+		flushed <- b.Flush()
+	}()
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Errorf("failed to spool batch: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("spooling was blocked by the replay sending to the inner sink")
+	}
+
+	inner.lock.Lock()
+	inner.block = nil
+	inner.lock.Unlock()
+	close(release)
+	<-done
+
+	if len(inner.batches) != 2 || inner.batches[0][0].Value != 1 || inner.batches[1][0].Value != 2 {
+		t.Errorf("expected both batches to be replayed in order, got %v", inner.batches)
+	}
+}
+
+func TestSpoolSinkCloseTwice(t *testing.T) {
+	inner := newFakeBatchSink()
+	s, cleanup := newTestSpoolSink(t, inner)
+	defer cleanup()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("close failed: %s", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second close failed: %s", err)
+	}
+	if inner.closed != 1 {
+		t.Errorf("expected the inner sink to be closed once, got %d", inner.closed)
+	}
+}
+
+func TestSpoolSinkRejectsLogSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewSpoolSink(NewLoggingSink(), &conf.SpoonConfigSpool{Directory: dir}); err == nil {
+		t.Error("expected the log sink to be rejected")
+	}
+}
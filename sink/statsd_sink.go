@@ -4,20 +4,60 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/AstromechZA/go-statsd"
 	"github.com/AstromechZA/spoon/conf"
 )
 
 type StatsdSink struct {
-	client *statsd.Client
+	client     *statsd.Client
+	nativeTags bool
+
+	// sendLock serialises the sending of batches, and errLock guards the
+	// last error reported by the statsd client while a batch is being sent
+	sendLock sync.Mutex
+	errLock  sync.Mutex
+	lastErr  error
 }
 
 type StatsdSinkSettings struct {
-	Address string `json:"address"`
+	Address   string `json:"address"`
+	TagFormat string `json:"tag_format"`
+}
+
+var statsdTagFormats = map[string]statsd.TagFormat{
+	"datadog":  statsd.Datadog,
+	"influxdb": statsd.InfluxDB,
 }
 
 func NewStatsdSink(cfg *conf.SpoonConfigSink) (*StatsdSink, error) {
+	s, err := parseStatsdSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &StatsdSink{nativeTags: s.TagFormat != ""}
+	options := []statsd.Option{
+		statsd.Address(s.Address),
+		statsd.ErrorHandler(sink.handleError),
+		statsd.LazyConnect(),
+		statsd.FlushesBetweenReconnect(10 * 60 * 5),
+	}
+	if s.TagFormat != "" {
+		options = append(options, statsd.TagsFormat(statsdTagFormats[s.TagFormat]))
+	}
+
+	client, err := statsd.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	sink.client = client
+	return sink, nil
+}
+
+func parseStatsdSettings(cfg *conf.SpoonConfigSink) (*StatsdSinkSettings, error) {
 	s := &StatsdSinkSettings{}
 	if err := json.Unmarshal(cfg.SettingsRaw, s); err != nil {
 		return nil, fmt.Errorf("failed to parse statsd settings: %s", err)
@@ -25,23 +65,107 @@ func NewStatsdSink(cfg *conf.SpoonConfigSink) (*StatsdSink, error) {
 	if s.Address == "" {
 		return nil, fmt.Errorf("statsd sink settings missing 'address'")
 	}
+	if _, ok := statsdTagFormats[s.TagFormat]; s.TagFormat != "" && !ok {
+		return nil, fmt.Errorf("statsd sink 'tag_format' must be datadog or influxdb")
+	}
+	return s, nil
+}
 
-	client, err := statsd.New(
-		statsd.Address(s.Address),
-		statsd.ErrorHandler(func(e error) {
-			log.Printf("Statsd sink error: %s", e)
-		}),
-		statsd.LazyConnect(),
-		statsd.FlushesBetweenReconnect(10*60*5),
-	)
-	if err != nil {
-		return nil, err
+// handleError logs errors from the statsd client and remembers them so that a
+// batch being sent can report the failure
+func (s *StatsdSink) handleError(e error) {
+	log.Printf("Statsd sink error: %s", e)
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	s.lastErr = e
+}
+
+// NewBatch returns a batch which is written to statsd and flushed in one go.
+// Statsd has no timestamps, so the metrics are recorded at the time they
+// arrive.
+func (s *StatsdSink) NewBatch(timestamp time.Time) Batch {
+	return newMetricBatch(timestamp, s.send)
+}
+
+// send writes the metrics and flushes the client, returning any error the
+// client reported while doing so. Over udp this only detects failures that the
+// local network stack knows about, such as there being no route to the host.
+func (s *StatsdSink) send(metrics []Metric) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+
+	s.errLock.Lock()
+	s.lastErr = nil
+	s.errLock.Unlock()
+
+	for _, m := range metrics {
+		replayMetric(s, m)
+	}
+	s.client.Flush()
+
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	if s.lastErr != nil {
+		return fmt.Errorf("failed to send to statsd: %s", s.lastErr)
+	}
+	return nil
+}
+
+// clientFor returns the client and path to use for a metric with the given
+// tags. Without a tag format, the tags are folded into the path.
+func (s *StatsdSink) clientFor(path string, tags []Tags) (*statsd.Client, string) {
+	merged := mergeTags(tags...)
+	if !s.nativeTags {
+		return s.client, ExpandPath(path, merged)
+	}
+	if len(merged) == 0 {
+		return s.client, StripPath(path)
+	}
+	pairs := make([]string, 0, len(merged)*2)
+	for _, k := range merged.sortedKeys() {
+		pairs = append(pairs, k, merged[k])
 	}
+	return s.client.Clone(statsd.Tags(pairs...)), StripPath(path)
+}
+
+// Gauge sends a path/value pair as a statsd gauge
+func (s *StatsdSink) Gauge(path string, value interface{}, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Gauge(p, value)
+}
+
+// Count sends a path/value pair as a statsd counter
+func (s *StatsdSink) Count(path string, n interface{}, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Count(p, n)
+}
+
+// Increment sends a statsd counter increment of one
+func (s *StatsdSink) Increment(path string, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Increment(p)
+}
+
+// Timing sends a path/value pair as a statsd timer
+func (s *StatsdSink) Timing(path string, value interface{}, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Timing(p, value)
+}
+
+// Set sends a path/member pair as a statsd set
+func (s *StatsdSink) Set(path string, member string, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Unique(p, member)
+}
 
-	return &StatsdSink{client: client}, nil
+// Histogram sends a path/value pair as a statsd histogram
+func (s *StatsdSink) Histogram(path string, value interface{}, tags ...Tags) {
+	c, p := s.clientFor(path, tags)
+	c.Histogram(p, value)
 }
 
-// Gauge writes a path/value pair to the log
-func (s *StatsdSink) Gauge(path string, value interface{}) {
-	s.client.Gauge(path, value)
+// Close flushes any buffered metrics and closes the statsd connection
+func (s *StatsdSink) Close() error {
+	s.client.Close()
+	return nil
 }
@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// SwitchSink passes metrics on to a parent sink which can be replaced while
+// agents are running, for example when the config is reloaded.
+type SwitchSink struct {
+	lock    sync.RWMutex
+	current *switchTarget
+
+	// rebuildLock serializes Rebuild and Close
+	rebuildLock sync.Mutex
+}
+
+// switchTarget is a parent sink. While it is being built, ready is open and
+// batches created for it hold their metrics until it is ready.
+type switchTarget struct {
+	sink     Sink
+	ready    chan struct{}
+	inFlight sync.WaitGroup
+}
+
+// switchBatch is a batch from a parent sink which marks itself as no longer in
+// flight when flushed
+type switchBatch struct {
+	Batch
+	once sync.Once
+	done func()
+}
+
+// directBatch is used for parent sinks that do not support batching. Metrics
+// are passed straight through and Flush does nothing.
+type directBatch struct {
+	Sink
+}
+
+// NewSwitchSink returns a SwitchSink which initially passes metrics to the
+// given sink. It always supports batching, whether or not the parent does.
+func NewSwitchSink(s Sink) *SwitchSink {
+	return &SwitchSink{current: newReadyTarget(s)}
+}
+
+func newReadyTarget(s Sink) *switchTarget {
+	t := &switchTarget{sink: s, ready: make(chan struct{})}
+	close(t.ready)
+	return t
+}
+
+// Rebuild replaces the current parent with the sink returned by build. New
+// batches go to the new parent straight away, but hold their metrics until it
+// is ready. Meanwhile, batches from the old parent are waited for, and the old
+// parent is closed before build is called, so the two never exist at the same
+// time and can use the same resources. If build fails, the parent is rebuilt
+// with fallback instead, and if that fails too the metrics are logged until
+// the next rebuild.
+func (s *SwitchSink) Rebuild(build, fallback func() (Sink, error)) error {
+	s.rebuildLock.Lock()
+	defer s.rebuildLock.Unlock()
+
+	next := &switchTarget{ready: make(chan struct{})}
+	s.lock.Lock()
+	previous := s.current
+	s.current = next
+	s.lock.Unlock()
+
+	// an agent may hold a batch from the old parent open while creating one
+	// for the new parent, so the lock must not be held while waiting
+	previous.inFlight.Wait()
+	if err := previous.sink.Close(); err != nil {
+		log.Printf("Failed to close the previous metric sink: %s", err)
+	}
+
+	var err error
+	next.sink, err = build()
+	if err != nil {
+		var ferr error
+		if next.sink, ferr = fallback(); ferr != nil {
+			log.Printf("Failed to rebuild the previous metric sink, logging metrics instead: %s", ferr)
+			next.sink = NewLoggingSink()
+		}
+	}
+	close(next.ready)
+	return err
+}
+
+// target returns the current parent, waiting until it is ready
+func (s *SwitchSink) target() *switchTarget {
+	s.lock.RLock()
+	target := s.current
+	s.lock.RUnlock()
+	<-target.ready
+	return target
+}
+
+// Gauge passes the gauge on to the current parent
+func (s *SwitchSink) Gauge(path string, value interface{}, tags ...Tags) {
+	s.target().sink.Gauge(path, value, tags...)
+}
+
+// Count passes the count on to the current parent
+func (s *SwitchSink) Count(path string, n interface{}, tags ...Tags) {
+	s.target().sink.Count(path, n, tags...)
+}
+
+// Increment passes the increment on to the current parent
+func (s *SwitchSink) Increment(path string, tags ...Tags) {
+	s.target().sink.Increment(path, tags...)
+}
+
+// Timing passes the timing on to the current parent
+func (s *SwitchSink) Timing(path string, value interface{}, tags ...Tags) {
+	s.target().sink.Timing(path, value, tags...)
+}
+
+// Set passes the set member on to the current parent
+func (s *SwitchSink) Set(path string, member string, tags ...Tags) {
+	s.target().sink.Set(path, member, tags...)
+}
+
+// Histogram passes the histogram value on to the current parent
+func (s *SwitchSink) Histogram(path string, value interface{}, tags ...Tags) {
+	s.target().sink.Histogram(path, value, tags...)
+}
+
+// NewBatch returns a batch for the current parent. The parent will not be
+// closed by Rebuild until the batch has been flushed. If the parent is still
+// being built, the metrics are held until the batch is flushed and the parent
+// is ready.
+func (s *SwitchSink) NewBatch(timestamp time.Time) Batch {
+	s.lock.RLock()
+	target := s.current
+	target.inFlight.Add(1)
+	s.lock.RUnlock()
+
+	select {
+	case <-target.ready:
+		return &switchBatch{Batch: target.newBatch(timestamp), done: target.inFlight.Done}
+	default:
+	}
+	pending := newMetricBatch(timestamp, func(metrics []Metric) error {
+		<-target.ready
+		b := target.newBatch(time.Unix(0, metrics[0].Timestamp))
+		for _, m := range metrics {
+			replayMetric(b, m)
+		}
+		return b.Flush()
+	})
+	return &switchBatch{Batch: pending, done: target.inFlight.Done}
+}
+
+// newBatch returns a batch from the parent, which must be ready
+func (t *switchTarget) newBatch(timestamp time.Time) Batch {
+	if bs, ok := t.sink.(BatchSink); ok {
+		return bs.NewBatch(timestamp)
+	}
+	return &directBatch{Sink: t.sink}
+}
+
+// Close closes the current parent once its batches have been flushed
+func (s *SwitchSink) Close() error {
+	s.rebuildLock.Lock()
+	defer s.rebuildLock.Unlock()
+	target := s.target()
+	target.inFlight.Wait()
+	return target.sink.Close()
+}
+
+// Flush flushes the parent batch and marks it as complete
+func (b *switchBatch) Flush() error {
+	err := b.Batch.Flush()
+	b.once.Do(b.done)
+	return err
+}
+
+// Close flushes the batch
+func (b *switchBatch) Close() error {
+	return b.Flush()
+}
+
+// Flush does nothing because the metrics have already been passed on
+func (b *directBatch) Flush() error {
+	return nil
+}
+
+// Close does nothing because the parent sink is still in use
+func (b *directBatch) Close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+package sink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSwitchSinkRebuildWithBatchHeldOpen(t *testing.T) {
+	old := newFakeBatchSink()
+	next := newFakeBatchSink()
+	s := NewSwitchSink(old)
+
+	// like an agent reporting metrics with their own timestamps, hold one batch
+	// open while the sink is rebuilt and then open another
+	first := s.NewBatch(time.Now())
+	first.Gauge("a", 1)
+
+	built := make(chan struct{})
+	rebuilt := make(chan error)
+	go func() {
+		rebuilt <- s.Rebuild(func() (Sink, error) {
+			if old.closed != 1 {
+				t.Error("expected the old sink to be closed before the new one is built")
+			}
+			close(built)
+			return next, nil
+		}, nil)
+	}()
+
+	// wait for the rebuild to swap in the new parent
+	for {
+		s.lock.RLock()
+		swapped := s.current.sink == nil
+		s.lock.RUnlock()
+		if swapped {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	opened := make(chan Batch)
+	go func() { opened <- s.NewBatch(time.Now()) }()
+	var second Batch
+	select {
+	case second = <-opened:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewBatch blocked while the sink was being rebuilt")
+	}
+	second.Gauge("b", 2)
+
+	select {
+	case <-built:
+		t.Fatal("the new sink was built before the old batch was flushed")
+	default:
+	}
+	if err := first.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-rebuilt:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Rebuild did not return")
+	}
+
+	if len(old.batches) != 1 || old.batches[0][0].Path != "a" {
+		t.Errorf("expected the first batch to go to the old sink, got %v", old.batches)
+	}
+	if len(next.batches) != 1 || next.batches[0][0].Path != "b" {
+		t.Errorf("expected the second batch to go to the new sink, got %v", next.batches)
+	}
+}
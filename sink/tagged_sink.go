@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"time"
+)
+
+// taggedSink adds a fixed set of tags to every metric passed through it. Tags
+// given with each metric take precedence over the fixed tags.
+type taggedSink struct {
+	inner Sink
+	tags  Tags
+}
+
+// taggedBatchSink is a taggedSink for a parent that supports batching
+type taggedBatchSink struct {
+	taggedSink
+	inner BatchSink
+}
+
+// taggedBatch is a Batch that adds fixed tags to every metric
+type taggedBatch struct {
+	taggedSink
+	inner Batch
+}
+
+// WithTags returns a Sink which adds the given tags to every metric before
+// passing it on. If the parent sink supports batching, so will the returned
+// sink.
+func WithTags(s Sink, tags Tags) Sink {
+	if len(tags) == 0 {
+		return s
+	}
+	if bs, ok := s.(BatchSink); ok {
+		return &taggedBatchSink{taggedSink: taggedSink{inner: s, tags: tags}, inner: bs}
+	}
+	return &taggedSink{inner: s, tags: tags}
+}
+
+func (s *taggedSink) merge(tags []Tags) Tags {
+	return mergeTags(append([]Tags{s.tags}, tags...)...)
+}
+
+// Gauge passes the gauge on with the extra tags
+func (s *taggedSink) Gauge(path string, value interface{}, tags ...Tags) {
+	s.inner.Gauge(path, value, s.merge(tags))
+}
+
+// Count passes the count on with the extra tags
+func (s *taggedSink) Count(path string, n interface{}, tags ...Tags) {
+	s.inner.Count(path, n, s.merge(tags))
+}
+
+// Increment passes the increment on with the extra tags
+func (s *taggedSink) Increment(path string, tags ...Tags) {
+	s.inner.Increment(path, s.merge(tags))
+}
+
+// Timing passes the timing on with the extra tags
+func (s *taggedSink) Timing(path string, value interface{}, tags ...Tags) {
+	s.inner.Timing(path, value, s.merge(tags))
+}
+
+// Set passes the set member on with the extra tags
+func (s *taggedSink) Set(path string, member string, tags ...Tags) {
+	s.inner.Set(path, member, s.merge(tags))
+}
+
+// Histogram passes the histogram value on with the extra tags
+func (s *taggedSink) Histogram(path string, value interface{}, tags ...Tags) {
+	s.inner.Histogram(path, value, s.merge(tags))
+}
+
+// Close closes the parent sink
+func (s *taggedSink) Close() error {
+	return s.inner.Close()
+}
+
+// NewBatch returns a batch from the parent sink which adds the extra tags
+func (s *taggedBatchSink) NewBatch(timestamp time.Time) Batch {
+	b := s.inner.NewBatch(timestamp)
+	return &taggedBatch{taggedSink: taggedSink{inner: b, tags: s.tags}, inner: b}
+}
+
+// Flush flushes the parent batch
+func (b *taggedBatch) Flush() error {
+	return b.inner.Flush()
+}
@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"sort"
+	"strings"
+)
+
+// Tags are key/value dimensions attached to a metric, like the device or
+// interface that it was measured on.
+//
+// Agents refer to tags in their metric paths using `{key}` segments, for
+// example `base.disk.{device}.read_bytes`. Sinks that support tags natively
+// strip these segments from the path and send the tags alongside it, while
+// other sinks fold the tag values into the path in place of the segments.
+type Tags map[string]string
+
+// mergeTags combines the given tag sets into a single set. Later sets take
+// precedence over earlier ones.
+func mergeTags(sets ...Tags) Tags {
+	if len(sets) == 0 {
+		return nil
+	}
+	if len(sets) == 1 {
+		return sets[0]
+	}
+	output := Tags{}
+	for _, set := range sets {
+		for k, v := range set {
+			output[k] = v
+		}
+	}
+	return output
+}
+
+// sortedKeys returns the keys of the tag set in a deterministic order
+func (t Tags) sortedKeys() []string {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isTagSegment returns the tag key if the path segment is of the form `{key}`
+func isTagSegment(segment string) (string, bool) {
+	if len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}' {
+		return segment[1 : len(segment)-1], true
+	}
+	return "", false
+}
+
+// ExpandPath folds the tags into the path by replacing each `{key}` segment
+// with the value of that tag. Segments referring to missing tags are dropped,
+// as are any tags that the path does not refer to.
+func ExpandPath(path string, tags Tags) string {
+	if !strings.Contains(path, "{") {
+		return path
+	}
+	parts := strings.Split(path, ".")
+	output := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if key, ok := isTagSegment(p); ok {
+			if v := tags[key]; v != "" {
+				output = append(output, v)
+			}
+			continue
+		}
+		output = append(output, p)
+	}
+	return strings.Join(output, ".")
+}
+
+// StripPath removes every `{key}` segment from the path. This is the path used
+// by sinks that send tags natively.
+func StripPath(path string) string {
+	if !strings.Contains(path, "{") {
+		return path
+	}
+	parts := strings.Split(path, ".")
+	output := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if _, ok := isTagSegment(p); !ok {
+			output = append(output, p)
+		}
+	}
+	return strings.Join(output, ".")
+}
+
+// formatTagsKey renders the tags as a deterministic string which can be used
+// as part of a map key.
+func formatTagsKey(tags Tags) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(tags))
+	for _, k := range tags.sortedKeys() {
+		parts = append(parts, k+"="+tags[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -9,11 +9,14 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/AstromechZA/spoon/agents"
 	"github.com/AstromechZA/spoon/conf"
 	"github.com/AstromechZA/spoon/constants"
 	"github.com/AstromechZA/spoon/sink"
+	"golang.org/x/net/context"
 )
 
 const usageString = `Spoon is a simple metric gatherer for Linux systems. Like the popular Diamond
@@ -106,28 +109,27 @@ func mainInner() error {
 	}
 
 	// build sink
-	activeSink, err := sink.BuildSink(&cfg.Sink)
+	activeSink, err := BuildActiveSink(cfg)
 	if err != nil {
 		return fmt.Errorf("Failed to setup metric sink: %s", err)
 	}
 
-	// build the list of real agents
-	agentList := make([]agents.Agent, len(cfg.Agents))
-	for i, c := range cfg.Agents {
-
-		if len(c.Path) > 0 && c.Path[0] == '.' {
-			c.Path = cfg.BasePath + c.Path
-		}
-
-		agent, aerr := agents.BuildAgent(&c)
-		if aerr != nil {
-			os.Exit(1)
-		}
-		agentList[i] = agent
-	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// run each agent once
 	if *onceFlag {
+		// build the list of real agents
+		agentConfigs := resolveAgentConfigs(cfg)
+		agentList := make([]agents.Agent, len(agentConfigs))
+		for i, c := range agentConfigs {
+			agent, aerr := agents.BuildAgent(&c)
+			if aerr != nil {
+				os.Exit(1)
+			}
+			agentList[i] = agent
+		}
+
 		hasErrors := false
 		group := sync.WaitGroup{}
 		for _, a := range agentList {
@@ -137,37 +139,119 @@ func mainInner() error {
 			}
 			group.Add(1)
 			go func(current agents.Agent) {
-				if aerr := current.Tick(activeSink.(sink.Sink)); aerr != nil {
+				if aerr := agents.TickAgent(ctx, current, activeSink); aerr != nil {
 					log.Printf("Error: %T: %s", current, aerr)
 					hasErrors = true
 				}
+				// agents like stream would otherwise leave a child running
+				agents.StopAgent(current)
 				group.Done()
 			}(a)
 		}
 		group.Wait()
+		if cerr := stopAndClose(cancel, nil, activeSink, shutdownTimeout(cfg)); cerr != nil {
+			return cerr
+		}
 		if hasErrors {
 			return fmt.Errorf("some agents had errors")
 		}
 		return nil
 	}
 
-	// now spawn each of the agents
-	for _, a := range agentList {
-		err = agents.SpawnAgent(a, activeSink.(sink.Sink))
-		if err != nil {
-			return fmt.Errorf("Failed to spawn agent %v: %s", a, err)
-		}
+	// now spawn each of the agents. The sink is wrapped so that it can be
+	// replaced on reload without restarting the agents.
+	switchSink := sink.NewSwitchSink(activeSink)
+	runner := newAgentRunner(ctx, switchSink)
+	if err = runner.apply(resolveAgentConfigs(cfg)); err != nil {
+		return err
 	}
 
-	// instead of sitting in a for loop or something, we wait for sigint
+	// instead of sitting in a for loop or something, we wait for sigint or
+	// sigterm. sighup reloads the config.
 	signalChannel := make(chan os.Signal, 1)
 	// notify that we are going to handle interrupts
-	signal.Notify(signalChannel, os.Interrupt)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	for sig := range signalChannel {
+		if sig == syscall.SIGHUP {
+			log.Printf("Received %v signal. Reloading config from %s", sig, configPath)
+			if newCfg, rerr := reloadConfig(configPath, cfg, switchSink, runner); rerr != nil {
+				log.Printf("Failed to reload config, continuing with the current config: %s", rerr)
+			} else {
+				cfg = newCfg
+			}
+			continue
+		}
 		log.Printf("Received %v signal. Stopping.", sig)
 		break
 	}
-	return nil
+	return stopAndClose(cancel, runner.doneChannels(), switchSink, shutdownTimeout(cfg))
+}
+
+// reloadConfig loads and validates the config file again. The sink is only
+// rebuilt if its config has changed, and only the agents that were added,
+// removed, or changed are stopped or started.
+func reloadConfig(configPath string, current *conf.SpoonConfig, switchSink *sink.SwitchSink, runner *agentRunner) (*conf.SpoonConfig, error) {
+	cfg, err := Load(&configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load config: %s", err)
+	}
+	if err = CleanAndValidate(cfg); err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %s", err)
+	}
+
+	if sinkFingerprint(cfg) != sinkFingerprint(current) {
+		// the old sink is closed before the new one is built, since they may
+		// share a spool directory or listen address
+		log.Printf("Sink config has changed, rebuilding the metric sink")
+		err = switchSink.Rebuild(func() (sink.Sink, error) {
+			return BuildActiveSink(cfg)
+		}, func() (sink.Sink, error) {
+			return BuildActiveSink(current)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to setup metric sink: %s", err)
+		}
+	}
+
+	if err = runner.apply(resolveAgentConfigs(cfg)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func shutdownTimeout(cfg *conf.SpoonConfig) time.Duration {
+	return time.Duration(cfg.ShutdownTimeout * float32(time.Second))
+}
+
+// stopAndClose cancels the running agents, waits for any in-flight ticks to
+// return, and then closes the sink so that buffered metrics are sent. It gives
+// up waiting once the timeout has passed.
+func stopAndClose(cancel context.CancelFunc, doneChannels []<-chan struct{}, activeSink sink.Sink, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	cancel()
+
+	for _, done := range doneChannels {
+		select {
+		case <-done:
+		case <-time.After(time.Until(deadline)):
+			log.Printf("Timed out waiting for agents to stop")
+		}
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- activeSink.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			return fmt.Errorf("Failed to close metric sink: %s", err)
+		}
+		log.Printf("Closed metric sink")
+		return nil
+	case <-time.After(time.Until(deadline)):
+		return fmt.Errorf("Timed out waiting for the metric sink to close")
+	}
 }
 
 func main() {